@@ -0,0 +1,278 @@
+// Command devoidc is a minimal OpenID Connect provider for trying out single
+// sign-on locally. It accepts any email address and name typed into its login
+// form, so it must never be exposed to anyone else.
+//
+// Run it alongside snippetbox with:
+//
+//	go run ./cmd/devoidc
+//	go run ./cmd/web -dev -oidc-issuer=http://localhost:5556 -oidc-client-id=snippetbox -oidc-client-secret=dev-secret
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// authCode is an issued authorization code, waiting to be exchanged.
+type authCode struct {
+	email       string
+	name        string
+	nonce       string
+	challenge   string
+	redirectURI string
+	expires     time.Time
+}
+
+// provider holds the state of the stand-in identity provider.
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*authCode
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:5556", "HTTP network address")
+	issuer := flag.String("issuer", "http://localhost:5556", "Issuer URL")
+	clientID := flag.String("client-id", "snippetbox", "Client ID to accept")
+	clientSecret := flag.String("client-secret", "dev-secret", "Client secret to accept")
+	flag.Parse()
+
+	// A fresh signing key is generated on every start, which is fine as ID
+	// tokens only need to be verified once, straight after they are issued.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := &provider{
+		issuer:       *issuer,
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		codes:        make(map[string]*authCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/keys", p.keys)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+
+	log.Printf("Starting stand-in OIDC provider %s on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (p *provider) keys(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "dev",
+			"n":   b64(pub.N.Bytes()),
+			"e":   b64(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Stand-in OIDC provider</title>
+<h1>Stand-in OIDC provider</h1>
+<p>Log in as anyone you like.</p>
+<form method="POST">
+    <p><label>Email: <input type="email" name="email" required></label></p>
+    <p><label>Name: <input type="text" name="name"></label></p>
+    <p><input type="submit" value="Log in"></p>
+</form>`))
+
+// authorize shows a login form on GET and issues an authorization code when it
+// is submitted.
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Form.Get("client_id") != p.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("response_type") != "code" {
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		loginForm.Execute(w, nil)
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = &authCode{
+		email:       r.PostForm.Get("email"),
+		name:        r.PostForm.Get("name"),
+		nonce:       r.Form.Get("nonce"),
+		challenge:   r.Form.Get("code_challenge"),
+		redirectURI: redirectURI.String(),
+		expires:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	q := redirectURI.Query()
+	q.Set("code", code)
+	q.Set("state", r.Form.Get("state"))
+	redirectURI.RawQuery = q.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token exchanges an authorization code for a signed ID token.
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	// Clients may authenticate with HTTP Basic auth or form parameters.
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != p.clientID || secret != p.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	p.mu.Lock()
+	code, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(code.expires) || code.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if b64(sum[:]) != code.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	subject := sha256.Sum256([]byte(code.email))
+	now := time.Now()
+	idToken, err := p.sign(map[string]interface{}{
+		"iss":            p.issuer,
+		"sub":            b64(subject[:16]),
+		"aud":            p.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          code.nonce,
+		"email":          code.email,
+		"email_verified": true,
+		"name":           code.name,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// sign returns the claims as a compact JWS signed with RS256.
+func (p *provider) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "dev"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + b64(sig), nil
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return b64(b)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Access log formats accepted by -access-log-format.
+const (
+	AccessLogCommon   = "common"   // NCSA Common Log Format
+	AccessLogCombined = "combined" // Common Log Format plus referer and user agent
+	AccessLogJSON     = "json"
+)
+
+// AccessLog writes a line for every request handled, in one of the formats
+// above.
+type AccessLog struct {
+	Format string
+	Out    io.Writer
+
+	mu sync.Mutex
+}
+
+// accessLogEntry holds what we know about a request once it's been handled.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	RemoteIP  string    `json:"remote_ip"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Size      int64     `json:"size"`
+	Duration  float64   `json:"duration_ms"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+func (l *AccessLog) write(e *accessLogEntry) error {
+	var line []byte
+	switch l.Format {
+	case AccessLogJSON:
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		line = append(b, '\n')
+	case AccessLogCommon, AccessLogCombined:
+		line = fmt.Appendf(nil, "%s - - [%s] %s %d %s",
+			e.RemoteIP, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			strconv.Quote(e.Method+" "+e.URI+" "+e.Proto), e.Status, clfSize(e.Size))
+		if l.Format == AccessLogCombined {
+			line = fmt.Appendf(line, " %s %s", clfQuote(e.Referer), clfQuote(e.UserAgent))
+		}
+		line = append(line, '\n')
+	default:
+		return fmt.Errorf("invalid access log format %q", l.Format)
+	}
+
+	// Lines must not be interleaved, whatever Out is.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err := l.Out.Write(line)
+	return err
+}
+
+// clfSize formats a response size the way the Common Log Format expects, with
+// "-" for an empty body.
+func clfSize(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// clfQuote quotes a header value, escaping anything which could break up the
+// line, with "-" for a missing one.
+func clfQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// responseRecorder wraps a ResponseWriter to capture the status code and the
+// number of bytes written.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
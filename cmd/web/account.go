@@ -0,0 +1,254 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vermeerp/snippetbox/pkg/forms"
+	"github.com/vermeerp/snippetbox/pkg/models"
+)
+
+// accountDeletionCooldown is how long after asking for their account to be
+// deleted a user has to change their mind.
+const accountDeletionCooldown = 7 * 24 * time.Hour
+
+// ExportData sends the current user a ZIP archive containing everything we
+// hold about them, as JSON files.
+func (app *App) ExportData(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	snippets, err := app.Database.UserSnippets(user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	sessions, err := app.Database.UserSessions(user.ID, "")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", exportProfile(user)},
+		{"snippets.json", exportSnippets(snippets)},
+		{"sessions.json", exportSessions(sessions)},
+	}
+
+	app.audit(r, user.ID, "user.export", userTarget(user.ID), "")
+
+	// Once we start writing the archive it's too late to send an error page,
+	// so errors after this point can only be logged.
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="snippetbox-data.zip"`)
+
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "exporting data", "user_id", user.ID, "error", err)
+			return
+		}
+
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(file.data)
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "exporting data", "user_id", user.ID, "error", err)
+			return
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "exporting data", "user_id", user.ID, "error", err)
+	}
+}
+
+func exportProfile(u *models.User) interface{} {
+	// omitempty doesn't leave out a zero time.Time, only a nil pointer.
+	var deletionRequested *time.Time
+	if !u.DeletionRequested.IsZero() {
+		deletionRequested = &u.DeletionRequested
+	}
+
+	return struct {
+		ID                int        `json:"id"`
+		Name              string     `json:"name"`
+		Email             string     `json:"email"`
+		Created           time.Time  `json:"created"`
+		Verified          bool       `json:"verified"`
+		TOTPEnabled       bool       `json:"totp_enabled"`
+		DeletionRequested *time.Time `json:"deletion_requested,omitempty"`
+	}{u.ID, u.Name, u.Email, u.Created, u.Verified, u.TOTPEnabled, deletionRequested}
+}
+
+func exportSnippets(snippets models.Snippets) interface{} {
+	type snippet struct {
+		ID      int       `json:"id"`
+		Title   string    `json:"title"`
+		Content string    `json:"content"`
+		Created time.Time `json:"created"`
+		Expires time.Time `json:"expires"`
+	}
+
+	out := []snippet{}
+	for _, s := range snippets {
+		out = append(out, snippet{s.ID, s.Title, s.Content, s.Created, s.Expires})
+	}
+	return out
+}
+
+func exportSessions(sessions []*models.UserSession) interface{} {
+	type session struct {
+		IP        string    `json:"ip"`
+		UserAgent string    `json:"user_agent"`
+		Created   time.Time `json:"created"`
+		LastSeen  time.Time `json:"last_seen"`
+	}
+
+	out := []session{}
+	for _, s := range sessions {
+		out = append(out, session{s.IP, s.UserAgent, s.Created, s.LastSeen})
+	}
+	return out
+}
+
+// ConfirmDeletion renders the form for deleting the current user's account.
+func (app *App) ConfirmDeletion(w http.ResponseWriter, r *http.Request) {
+	reauthenticated, err := app.reauthenticated(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "settings-delete.page.html", &HTMLData{
+		Form: &forms.ConfirmPassword{Reauthenticated: reauthenticated},
+	})
+}
+
+// DeleteAccount schedules the current user's account for deletion, after
+// checking their password (or that they've just reauthenticated). The account
+// and its snippets are removed by PurgeAccounts once the cooldown period has
+// passed.
+func (app *App) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	reauthenticated, err := app.reauthenticated(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	form := &forms.ConfirmPassword{
+		Password:        r.PostForm.Get("password"),
+		Reauthenticated: reauthenticated,
+	}
+
+	if !form.Valid() {
+		app.RenderHTML(w, r, "settings-delete.page.html", &HTMLData{Form: form})
+		return
+	}
+
+	if !form.Reauthenticated {
+		err = app.Database.VerifyPassword(user.ID, form.Password)
+		if err == models.ErrInvalidCredentials {
+			form.Failures["Password"] = "Password is incorrect"
+			app.RenderHTML(w, r, "settings-delete.page.html", &HTMLData{Form: form})
+			return
+		} else if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+
+	err = app.Database.RequestDeletion(user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "user.delete_request", userTarget(user.ID), "")
+
+	when := humanDate(time.Now().Add(accountDeletionCooldown))
+	err = app.Mailer.Send(user.Email, "Your Snippetbox account will be deleted",
+		fmt.Sprintf("Your Snippetbox account and all of your snippets will be deleted on %s.\n\n"+
+			"If you didn't ask for this, or have changed your mind, log in and cancel the deletion from your settings page.\n", when))
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "sending deletion notice", "user_id", user.ID, "error", err)
+	}
+
+	app.redirectWithFlash(w, r, "/user/settings", "Your account will be deleted on "+when+".")
+}
+
+// CancelDeletion cancels the scheduled deletion of the current user's account.
+func (app *App) CancelDeletion(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.CancelDeletion(user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "user.delete_cancel", userTarget(user.ID), "")
+
+	app.redirectWithFlash(w, r, "/user/settings", "Your account will no longer be deleted.")
+}
+
+// PurgeAccounts deletes the accounts whose cooldown period has passed, checking
+// every interval until ctx is cancelled.
+func (app *App) PurgeAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		users, err := app.Database.PurgeDeletedUsers(accountDeletionCooldown)
+		if err != nil {
+			app.Logger.Error("purging deleted accounts", "error", err)
+			continue
+		}
+
+		for _, user := range users {
+			app.audit(nil, 0, "user.purge", userTarget(user.ID), "")
+
+			// Forget any failed logins, which are recorded by email address.
+			err = app.Database.ClearLoginFailures(accountKey(user.Email))
+			if err != nil {
+				app.Logger.Error("purging deleted accounts", "error", err)
+			}
+		}
+		if len(users) > 0 {
+			app.Logger.Info("purged deleted accounts", "count", len(users))
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/vermeerp/snippetbox/pkg/models"
+)
+
+// adminPageSize is the number of rows shown on each page of the admin lists.
+const adminPageSize = 50
+
+// AdminHome renders the index of the admin console.
+func (app *App) AdminHome(w http.ResponseWriter, r *http.Request) {
+	app.RenderHTML(w, r, "admin.page.html", nil)
+}
+
+// AdminUsers lists users, optionally filtered by a search query on their name
+// or email address.
+func (app *App) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	search := r.URL.Query().Get("q")
+	page := pageNumber(r)
+
+	users, err := app.Database.ListUsers(search, adminPageSize, (page-1)*adminPageSize)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "admin-users.page.html", &HTMLData{
+		Page:   page,
+		Search: search,
+		Users:  users,
+	})
+}
+
+// AdminShowUser displays a user along with the actions an admin can take on
+// their account.
+func (app *App) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.renderAdminUser(w, r, user, &HTMLData{Flash: flash})
+}
+
+// AdminDisableUser disables a user's account and logs them out everywhere.
+func (app *App) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+	if app.isCurrentUser(r, user) {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err := app.Database.SetUserDisabled(user.ID, true)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.DeleteUserSessions(user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, app.currentUserID(r), "admin.user_disable", userTarget(user.ID), "")
+
+	app.redirectWithFlash(w, r, adminUserURL(user), "The account has been disabled.")
+}
+
+// AdminEnableUser re-enables a disabled account.
+func (app *App) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	err := app.Database.SetUserDisabled(user.ID, false)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, app.currentUserID(r), "admin.user_enable", userTarget(user.ID), "")
+
+	app.redirectWithFlash(w, r, adminUserURL(user), "The account has been enabled.")
+}
+
+// AdminResetCredentials replaces a user's password with a random temporary one,
+// turns off their two-factor authentication and logs them out everywhere. The
+// temporary password is displayed once, for the admin to pass on.
+func (app *App) AdminResetCredentials(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	password := token[:16]
+
+	err = app.Database.UpdatePassword(user.ID, password)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.DisableTOTP(user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.DeleteUserSessions(user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.ClearLoginFailures(accountKey(user.Email))
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, app.currentUserID(r), "admin.credentials_reset", userTarget(user.ID), "")
+
+	// Reload the user so the page reflects the change to two-factor
+	// authentication.
+	user, err = app.Database.GetUser(user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.renderAdminUser(w, r, user, &HTMLData{
+		Flash:             "The credentials have been reset.",
+		TemporaryPassword: password,
+	})
+}
+
+// AdminUnlockUser lifts a login lockout on a user's account.
+func (app *App) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	err := app.Database.ClearLoginFailures(accountKey(user.Email))
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, app.currentUserID(r), "admin.user_unlock", userTarget(user.ID), "")
+
+	app.redirectWithFlash(w, r, adminUserURL(user), "The account has been unlocked.")
+}
+
+// AdminSetRole changes a user's role. Admins can't change their own role, so
+// that there is always at least one admin.
+func (app *App) AdminSetRole(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+	if app.isCurrentUser(r, user) {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Database.SetUserRole(user.ID, r.PostForm.Get("role"))
+	if err == models.ErrInvalidRole {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, app.currentUserID(r), "admin.role_change", userTarget(user.ID), user.Role+" -> "+r.PostForm.Get("role"))
+
+	app.redirectWithFlash(w, r, adminUserURL(user), "The role has been changed.")
+}
+
+// AdminSnippets lists all snippets, including expired ones.
+func (app *App) AdminSnippets(w http.ResponseWriter, r *http.Request) {
+	page := pageNumber(r)
+
+	snippets, err := app.Database.ListSnippets(adminPageSize, (page-1)*adminPageSize)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "admin-snippets.page.html", &HTMLData{
+		Flash:    flash,
+		Page:     page,
+		Snippets: snippets,
+	})
+}
+
+// AdminShowSnippet displays any snippet, even if it has expired.
+func (app *App) AdminShowSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.adminSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "admin-snippet.page.html", &HTMLData{
+		Flash:   flash,
+		Snippet: snippet,
+	})
+}
+
+// AdminHideSnippet hides a snippet from public view.
+func (app *App) AdminHideSnippet(w http.ResponseWriter, r *http.Request) {
+	app.setSnippetHidden(w, r, true)
+}
+
+// AdminUnhideSnippet makes a hidden snippet visible again.
+func (app *App) AdminUnhideSnippet(w http.ResponseWriter, r *http.Request) {
+	app.setSnippetHidden(w, r, false)
+}
+
+func (app *App) setSnippetHidden(w http.ResponseWriter, r *http.Request, hidden bool) {
+	snippet := app.adminSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	err := app.Database.SetSnippetHidden(snippet.ID, hidden)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	event, msg := "admin.snippet_unhide", "The snippet is visible again."
+	if hidden {
+		event, msg = "admin.snippet_hide", "The snippet has been hidden."
+	}
+	app.audit(r, app.currentUserID(r), event, snippetTarget(snippet.ID), "")
+	app.redirectWithFlash(w, r, fmt.Sprintf("/admin/snippets/%d", snippet.ID), msg)
+}
+
+// AdminDeleteSnippet deletes any snippet.
+func (app *App) AdminDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.adminSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	err := app.Database.DeleteSnippet(snippet.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, app.currentUserID(r), "admin.snippet_delete", snippetTarget(snippet.ID), "")
+
+	app.redirectWithFlash(w, r, "/admin/snippets", fmt.Sprintf("Snippet #%d has been deleted.", snippet.ID))
+}
+
+// adminUser loads the user identified by the :id URL parameter. If there is no
+// such user, or something goes wrong, it sends an error response and returns
+// nil.
+func (app *App) adminUser(w http.ResponseWriter, r *http.Request) *models.User {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.NotFound(w, r)
+		return nil
+	}
+
+	user, err := app.Database.GetUser(id)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil
+	}
+	if user == nil {
+		app.NotFound(w, r)
+		return nil
+	}
+
+	return user
+}
+
+// adminSnippet loads the snippet identified by the :id URL parameter, in the
+// same way as adminUser.
+func (app *App) adminSnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.NotFound(w, r)
+		return nil
+	}
+
+	snippet, err := app.Database.GetAnySnippet(id)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil
+	}
+	if snippet == nil {
+		app.NotFound(w, r)
+		return nil
+	}
+
+	return snippet
+}
+
+// renderAdminUser renders the admin page for the user, adding its login
+// lockout status to the data.
+func (app *App) renderAdminUser(w http.ResponseWriter, r *http.Request, user *models.User, data *HTMLData) {
+	var err error
+	data.LockedUntil, err = app.Database.LoginLockedUntil(accountKey(user.Email))
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	data.Roles = models.Roles
+	data.User = user
+	app.RenderHTML(w, r, "admin-user.page.html", data)
+}
+
+// isCurrentUser reports whether the user is the one making the request.
+func (app *App) isCurrentUser(r *http.Request, user *models.User) bool {
+	session := app.Sessions.Load(r)
+	id, err := session.GetInt("currentUserID")
+	return err == nil && id == user.ID
+}
+
+func adminUserURL(user *models.User) string {
+	return fmt.Sprintf("/admin/users/%d", user.ID)
+}
+
+// pageNumber returns the page requested in the "page" query string parameter,
+// defaulting to the first.
+func pageNumber(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
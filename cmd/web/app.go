@@ -1,18 +1,39 @@
 package main
 
 import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
 	"github.com/alexedwards/scs"
+	"github.com/vermeerp/snippetbox/pkg/mailer"
 	"github.com/vermeerp/snippetbox/pkg/models"
 )
 
 // App struct to hold the application-wide dependencies and configuration
 // settings for our web application.
 type App struct {
-	Addr      string // Add an Addr field
-	Database  *models.Database
-	HTMLDir   string
-	Sessions  *scs.Manager
-	StaticDir string
-	TLSCert   string // Add a TLSCert field
-	TLSKey    string // Add a TLSKey field
+	AccessLog     *AccessLog
+	Addr          string // Add an Addr field
+	AuditKey      []byte // key for the hashes of email addresses in the audit log
+	BaseURL       string // where the site is reached, for links in emails
+	Certs         *CertReloader
+	ClientCerts   *ClientCerts // nil if client certificates aren't used
+	Database      *models.Database
+	DrainTimeout  time.Duration // time allowed for requests to finish on shutdown
+	HSTSMaxAge    time.Duration // how long browsers should stick to HTTPS
+	HTTPAddr      string        // where to redirect plain HTTP to HTTPS, if anywhere
+	Logger        *slog.Logger
+	Mailer        mailer.Mailer
+	Metrics       *Metrics
+	MetricsAddr   string        // where to serve metrics, separately from the app
+	OIDC          *OIDC         // nil if single sign-on isn't configured
+	ReadyTimeout  time.Duration // time allowed for the /readyz checks
+	SecretPolicy  string        // what to do with credentials found in snippets
+	ShutdownDelay time.Duration // time to keep serving once /readyz fails on shutdown
+	Sessions      *scs.Manager
+	Static        *Static
+	Templates     *Templates
+
+	shuttingDown atomic.Bool // set once shutdown starts, failing /readyz
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vermeerp/snippetbox/pkg/models"
+)
+
+// audit appends an event to the audit log. The actor is the ID of the user
+// responsible (0 if nobody is logged in) and the target identifies what was
+// acted on, such as "user:42" or "snippet:7". The log can never be changed, so
+// it mustn't hold anything which has to be erased when an account is deleted,
+// such as email addresses; use emailTarget for those. Failing to write the log
+// doesn't fail the request, so errors are only logged. The request may be nil
+// for events which happen in the background.
+func (app *App) audit(r *http.Request, actorID int, event, target, details string) {
+	e := &models.AuditEvent{
+		Event:   event,
+		ActorID: actorID,
+		Target:  target,
+		Details: details,
+	}
+	ctx := context.Background()
+	if r != nil {
+		e.IP = clientIP(r)
+		e.UserAgent = r.UserAgent()
+		ctx = r.Context()
+	}
+
+	err := app.Database.InsertAuditEvent(e)
+	if err != nil {
+		app.Logger.ErrorContext(ctx, "writing audit event", "event", event, "error", err)
+	}
+}
+
+func userTarget(id int) string {
+	return fmt.Sprintf("user:%d", id)
+}
+
+func snippetTarget(id int) string {
+	return fmt.Sprintf("snippet:%d", id)
+}
+
+// emailTarget identifies an email address by a keyed hash of it, for events
+// where there's no user ID, such as failed logins. Events about the same
+// address share a target, but neither the address nor anything which could be
+// matched against a list of addresses is stored.
+func (app *App) emailTarget(email string) string {
+	mac := hmac.New(sha256.New, app.AuditKey)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "email-hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// auditExportWriteTimeout is how long AdminExportAudit may take to write each
+// entry of the audit log.
+const auditExportWriteTimeout = 10 * time.Second
+
+// AdminAudit displays the audit log, filtered by the query string parameters
+// "event" (a prefix), "actor" (a user ID), "from" and "to" (dates).
+func (app *App) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	page := pageNumber(r)
+
+	events, err := app.Database.ListAuditEvents(filter, adminPageSize, (page-1)*adminPageSize)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "admin-audit.page.html", &HTMLData{
+		AuditEvents: events,
+		Page:        page,
+		Query:       r.URL.Query(),
+	})
+}
+
+// AdminExportAudit sends the audit log entries matching the same filters as
+// AdminAudit as JSON lines, oldest first.
+func (app *App) AdminExportAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+
+	// The log can be too long to send within the server's write timeout, so
+	// the deadline is pushed back as each entry is written. Writers which
+	// don't support deadlines have none to push back.
+	rc := http.NewResponseController(w)
+
+	// Once the first entry is written it's too late to send an error page, so
+	// errors can only be logged.
+	enc := json.NewEncoder(w)
+	err = app.Database.EachAuditEvent(filter, func(e *models.AuditEvent) error {
+		err := rc.SetWriteDeadline(time.Now().Add(auditExportWriteTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+
+		return enc.Encode(struct {
+			ID        int64     `json:"id"`
+			Time      time.Time `json:"time"`
+			Event     string    `json:"event"`
+			ActorID   int       `json:"actor_id,omitempty"`
+			Target    string    `json:"target,omitempty"`
+			IP        string    `json:"ip,omitempty"`
+			UserAgent string    `json:"user_agent,omitempty"`
+			Details   string    `json:"details,omitempty"`
+		}{e.ID, e.Created, e.Event, e.ActorID, e.Target, e.IP, e.UserAgent, e.Details})
+	})
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "exporting audit log", "error", err)
+	}
+}
+
+// auditFilter builds a filter from the query string of the request.
+func auditFilter(r *http.Request) (models.AuditFilter, error) {
+	q := r.URL.Query()
+	f := models.AuditFilter{Event: q.Get("event")}
+
+	var err error
+	if v := q.Get("actor"); v != "" {
+		f.ActorID, err = strconv.Atoi(v)
+		if err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		f.Since, err = time.Parse("2006-01-02", v)
+		if err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		f.Until, err = time.Parse("2006-01-02", v)
+		if err != nil {
+			return f, err
+		}
+		// Include the whole of the last day.
+		f.Until = f.Until.AddDate(0, 0, 1)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmailTarget(t *testing.T) {
+	app := &App{AuditKey: []byte("key one")}
+
+	// Variations of the same address must share a target.
+	want := app.emailTarget("alice@example.com")
+	for _, email := range []string{"Alice@Example.com", "  alice@example.com ", "ALICE@EXAMPLE.COM"} {
+		if got := app.emailTarget(email); got != want {
+			t.Errorf("emailTarget(%q) = %q, want %q", email, got, want)
+		}
+	}
+
+	if !strings.HasPrefix(want, "email-hmac:") || len(want) != len("email-hmac:")+16 {
+		t.Errorf("emailTarget = %q, want email-hmac: and 16 hex digits", want)
+	}
+	if strings.Contains(want, "alice") {
+		t.Errorf("emailTarget = %q contains the address", want)
+	}
+
+	if got := app.emailTarget("bob@example.com"); got == want {
+		t.Errorf("different addresses share the target %q", got)
+	}
+
+	// Without the key the target can't be recomputed.
+	other := &App{AuditKey: []byte("key two")}
+	if got := other.emailTarget("alice@example.com"); got == want {
+		t.Errorf("different keys give the same target %q", got)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certExpiryWarning is how long before its expiry we start warning about a
+// certificate.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// CertReloader serves the TLS certificate in CertFile and KeyFile through
+// tls.Config.GetCertificate, so that it can be replaced without a restart.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+	Logger   *slog.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // the newer of the two files' modification times
+}
+
+// NewStaticCert serves a certificate which only exists in memory, and so is
+// never reloaded.
+func NewStaticCert(cert tls.Certificate, logger *slog.Logger) (*CertReloader, error) {
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		cert.Leaf = leaf
+	}
+
+	return &CertReloader{Logger: logger, cert: &cert}, nil
+}
+
+// NewCertReloader loads the certificate, failing if it can't be used.
+func NewCertReloader(certFile, keyFile string, logger *slog.Logger) (*CertReloader, error) {
+	cr := &CertReloader{CertFile: certFile, KeyFile: keyFile, Logger: logger}
+	err := cr.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate returns the current certificate.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return cr.cert, nil
+}
+
+// Reload reads the certificate and key again. The new pair is only swapped in
+// if it is valid, so a half-written or mismatched pair leaves the old one in
+// place.
+func (cr *CertReloader) Reload() error {
+	modTime, err := cr.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.CertFile, cr.KeyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("certificate %s expired on %s", cr.CertFile, leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.mu.Unlock()
+
+	cr.Logger.Info("loaded TLS certificate", "file", cr.CertFile, "subject", leaf.Subject.String(), "expires", leaf.NotAfter.UTC())
+	cr.checkExpiry()
+	return nil
+}
+
+// checkExpiry warns if the certificate expires soon.
+func (cr *CertReloader) checkExpiry() {
+	cr.mu.RLock()
+	leaf := cr.cert.Leaf
+	cr.mu.RUnlock()
+
+	if left := time.Until(leaf.NotAfter); left < certExpiryWarning {
+		cr.Logger.Warn("TLS certificate expires soon", "file", cr.CertFile, "expires", leaf.NotAfter.UTC(), "days_left", int(left.Hours()/24))
+	}
+}
+
+// Watch reloads the certificate on SIGHUP, and every interval if the files
+// have changed (0 to only reload on SIGHUP), until ctx is cancelled. Failed
+// reloads are logged and the old certificate is kept. A static certificate is
+// never reloaded, and SIGHUP is ignored rather than left to kill the server.
+func (cr *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	if cr.CertFile == "" {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				cr.Logger.Info("ignoring SIGHUP, as the TLS certificate is temporary")
+			}
+		}
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// Keep reminding about a certificate which is about to expire.
+	daily := time.NewTicker(24 * time.Hour)
+	defer daily.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			cr.reload()
+		case <-tick:
+			modTime, err := cr.filesModTime()
+			if err != nil {
+				cr.Logger.Error("checking TLS certificate", "error", err)
+				continue
+			}
+
+			// Look for any change, as a replacement may be older, for example
+			// if it was copied with its timestamp preserved.
+			cr.mu.RLock()
+			changed := !modTime.Equal(cr.modTime)
+			cr.mu.RUnlock()
+			if changed {
+				cr.reload()
+			}
+		case <-daily.C:
+			cr.checkExpiry()
+		}
+	}
+}
+
+func (cr *CertReloader) reload() {
+	err := cr.Reload()
+	if err != nil {
+		cr.Logger.Error("reloading TLS certificate, keeping the old one", "error", err)
+	}
+}
+
+func (cr *CertReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{cr.CertFile, cr.KeyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
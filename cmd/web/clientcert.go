@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/vermeerp/snippetbox/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Route groups which can be restricted to holders of client certificates with
+// -client-cert-routes.
+const (
+	ClientCertAdmin   = "admin"   // the admin console
+	ClientCertMetrics = "metrics" // the metrics listener
+)
+
+// ClientCertIdentity is who a client certificate belongs to: either a user,
+// identified by their email address, or anyone holding the given role.
+type ClientCertIdentity struct {
+	User string `yaml:"user"`
+	Role string `yaml:"role"`
+}
+
+// ClientCerts verifies client certificates and maps their subjects to users
+// or roles.
+type ClientCerts struct {
+	CAs    *x509.CertPool
+	Map    map[string]ClientCertIdentity // keyed by subject, e.g. "CN=alice,O=Example Corp"
+	Routes map[string]bool               // route groups which require a certificate
+}
+
+// LoadClientCerts reads the CA bundle which client certificates must be
+// signed by, and the YAML file mapping their subjects to identities:
+//
+//	"CN=Alice,OU=Engineering,O=Example Corp":
+//	  user: alice@example.com
+//	"CN=prometheus,O=Example Corp":
+//	  role: admin
+func LoadClientCerts(caFile, mapFile string, routes []string) (*ClientCerts, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", caFile)
+	}
+
+	cc := &ClientCerts{CAs: pool, Routes: map[string]bool{}}
+
+	if mapFile != "" {
+		b, err := os.ReadFile(mapFile)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.Unmarshal(b, &cc.Map)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", mapFile, err)
+		}
+	}
+	for subject, id := range cc.Map {
+		if (id.User == "") == (id.Role == "") {
+			return nil, fmt.Errorf("%s: %q must have either a user or a role", mapFile, subject)
+		}
+		if id.Role != "" && !models.ValidRole(id.Role) {
+			return nil, fmt.Errorf("%s: %q has an invalid role %q", mapFile, subject, id.Role)
+		}
+	}
+
+	for _, route := range routes {
+		switch route {
+		case ClientCertAdmin, ClientCertMetrics:
+			cc.Routes[route] = true
+		default:
+			return nil, fmt.Errorf("invalid client certificate route group %q", route)
+		}
+	}
+
+	return cc, nil
+}
+
+// errNoClientCert is returned when a request has no verified client
+// certificate with a known subject.
+var errNoClientCert = errors.New("no recognised client certificate")
+
+// clientCertRole returns the role the request's verified client certificate
+// grants, and the ID of the user it belongs to if it's mapped to a user rather
+// than a role.
+func (app *App) clientCertRole(r *http.Request) (role string, userID int, err error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", 0, errNoClientCert
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject.String()
+	id, ok := app.ClientCerts.Map[subject]
+	if !ok {
+		return "", 0, errNoClientCert
+	}
+	if id.Role != "" {
+		return id.Role, 0, nil
+	}
+
+	user, err := app.Database.GetUserByEmail(id.User)
+	if err != nil {
+		return "", 0, err
+	}
+	if user == nil || user.Disabled {
+		return "", 0, errNoClientCert
+	}
+
+	return user.Role, user.ID, nil
+}
+
+// RequireClientCert guards routes which should only be accessed with a
+// verified client certificate granting the given role (or a more privileged
+// one). If the certificate belongs to a user, then whoever is logged in must
+// be that user.
+func (app *App) RequireClientCert(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		certRole, certUserID, err := app.clientCertRole(r)
+		if err == errNoClientCert {
+			app.Logger.WarnContext(r.Context(), "client certificate required", "path", r.URL.Path)
+			app.ClientError(w, r, http.StatusForbidden)
+			return
+		} else if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+
+		if !models.RoleAtLeast(certRole, role) {
+			app.ClientError(w, r, http.StatusForbidden)
+			return
+		}
+
+		if certUserID != 0 {
+			userID := app.currentUserID(r)
+			if userID != 0 && userID != certUserID {
+				app.ClientError(w, r, http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientCertRoutes splits the -client-cert-routes setting.
+func clientCertRoutes(s string) []string {
+	var routes []string
+	for _, route := range strings.Split(s, ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environments accepted by -env.
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// defaultDSN is only good enough for a local development database. It is
+// refused in production, along with the other placeholder secrets below.
+const defaultDSN = "sb:u4UHCQQs#Agoqgi@/snippetbox?parseTime=true"
+
+// placeholderSecrets are values which must never be used in production: the
+// password in defaultDSN, the client secret accepted by cmd/devoidc and a few
+// usual suspects.
+var placeholderSecrets = []string{"u4UHCQQs#Agoqgi", "dev-secret", "changeme", "password", "secret"}
+
+// secretSettings are the settings masked by `config print`.
+var secretSettings = map[string]bool{
+	"audit-key":          true,
+	"dsn":                true,
+	"oidc-client-secret": true,
+	"smtp-password":      true,
+}
+
+// Config holds the server's settings. Each one comes from, in increasing order
+// of precedence, its default, the config file, an environment variable and a
+// command-line flag. The file is YAML with the flag names as keys, and the
+// environment variable for -some-flag is SNIPPETBOX_SOME_FLAG.
+type Config struct {
+	AccessLog           string
+	AccessLogFormat     string
+	AccessLogMaxBackups int
+	AccessLogMaxSize    int64
+	Addr                string
+	AuditKey            string
+	BaseURL             string
+	ClientCA            string
+	ClientCertMap       string
+	ClientCertRoutes    string
+	ConfigFile          string
+	Dev                 bool
+	DrainTimeout        time.Duration
+	DSN                 string
+	Env                 string
+	HSTSMaxAge          time.Duration
+	HTMLDir             string
+	HTTPAddr            string
+	LogFormat           string
+	LogLevel            string
+	MetricsAddr         string
+	OIDCClientID        string
+	OIDCClientSecret    string
+	OIDCIssuer          string
+	OIDCRedirectURL     string
+	ReadyTimeout        time.Duration
+	SecretPolicy        string
+	ShutdownDelay       time.Duration
+	SMTPAddr            string
+	SMTPFrom            string
+	SMTPPassword        string
+	SMTPUsername        string
+	StaticDir           string
+	TLSCert             string
+	TLSKey              string
+	TLSReloadInterval   time.Duration
+
+	// Sources records where each setting came from: "default", "file",
+	// "env" or "flag".
+	Sources map[string]string
+}
+
+// flagSet defines a flag for each setting, bound to the fields of cfg.
+func (cfg *Config) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	// Define command-line flags for the network address and location of the static
+	// files directory.
+	fs.StringVar(&cfg.AccessLog, "access-log", "", "Path to the access log file (if empty, it is written to stdout)")
+	fs.StringVar(&cfg.AccessLogFormat, "access-log-format", AccessLogCombined, "Access log format: common, combined or json")
+	fs.Int64Var(&cfg.AccessLogMaxSize, "access-log-max-size", 100, "Size in megabytes at which the access log file is rotated (0 to never rotate)")
+	fs.IntVar(&cfg.AccessLogMaxBackups, "access-log-max-backups", 5, "Number of rotated access log files to keep")
+	fs.StringVar(&cfg.Addr, "addr", ":4000", "HTTP network address")
+	fs.StringVar(&cfg.AuditKey, "audit-key", "", "Secret key for the hashes which identify email addresses in the audit log (required in production)")
+	fs.StringVar(&cfg.BaseURL, "base-url", "https://localhost:4000", "URL the site is reached at, used for the links in emails")
+	fs.StringVar(&cfg.ClientCA, "client-ca", "", "Path to the CA certificates which sign client certificates (if empty, they aren't asked for)")
+	fs.StringVar(&cfg.ClientCertMap, "client-cert-map", "", "Path to a YAML file mapping client certificate subjects to users or roles")
+	fs.StringVar(&cfg.ClientCertRoutes, "client-cert-routes", "admin,metrics", "Comma-separated route groups which need a client certificate: admin, metrics")
+	fs.StringVar(&cfg.ConfigFile, "config", "", "Path to a YAML config file")
+	fs.BoolVar(&cfg.Dev, "dev", false, "Development mode: use a temporary TLS certificate if -tls-cert and -tls-key don't exist, and reload templates when they change")
+	fs.DurationVar(&cfg.DrainTimeout, "drain-timeout", 30*time.Second, "Time allowed for in-flight requests to finish when shutting down")
+	fs.StringVar(&cfg.DSN, "dsn", defaultDSN, "MySQL DSN")
+	fs.StringVar(&cfg.Env, "env", EnvProduction, "Environment: production (which refuses placeholder secrets) or development (the default with -dev)")
+	fs.DurationVar(&cfg.HSTSMaxAge, "hsts-max-age", 365*24*time.Hour, "How long browsers should only use HTTPS for the site, sent over TLS in the Strict-Transport-Security header (0 to not send it)")
+	fs.StringVar(&cfg.HTMLDir, "html-dir", "", "Path to HTML templates overriding the built-in ones, file by file")
+	fs.StringVar(&cfg.HTTPAddr, "http-addr", "", "Plain HTTP network address which redirects to HTTPS (if empty, there isn't one)")
+	fs.StringVar(&cfg.LogFormat, "log-format", LogFormatText, "Log format: text (logfmt) or json")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "Minimum level to log: debug, info, warn or error")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", "localhost:4001", "HTTP network address for Prometheus metrics (if empty, they aren't served)")
+	fs.StringVar(&cfg.OIDCIssuer, "oidc-issuer", "", "OpenID Connect issuer URL (if empty, single sign-on is disabled)")
+	fs.StringVar(&cfg.OIDCClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	fs.StringVar(&cfg.OIDCClientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	fs.StringVar(&cfg.OIDCRedirectURL, "oidc-redirect-url", "https://localhost:4000/user/login/oidc/callback", "OpenID Connect redirect URL")
+	fs.DurationVar(&cfg.ReadyTimeout, "ready-timeout", 2*time.Second, "Time allowed for the /readyz checks")
+	fs.StringVar(&cfg.SecretPolicy, "secret-policy", SecretPolicyConfirm, "What to do with credentials found in snippets: block, redact or confirm")
+	fs.DurationVar(&cfg.ShutdownDelay, "shutdown-delay", 5*time.Second, "Time to keep serving after /readyz starts failing when shutting down, so that load balancers can stop sending traffic (should be longer than their probe interval)")
+	fs.StringVar(&cfg.SMTPAddr, "smtp-addr", "", "SMTP server address (if empty, emails are written to the log)")
+	fs.StringVar(&cfg.SMTPFrom, "smtp-from", "Snippetbox <no-reply@snippetbox.local>", "Sender address for emails")
+	fs.StringVar(&cfg.SMTPUsername, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.SMTPPassword, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.StaticDir, "static-dir", "", "Path to static assets overriding the built-in ones, file by file")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "./tls/cert.pem", "Path to TLS certificate")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "./tls/key.pem", "Path to TLS key")
+	fs.DurationVar(&cfg.TLSReloadInterval, "tls-reload-interval", time.Minute, "How often to check the TLS certificate and key for changes (0 to only reload them on SIGHUP)")
+
+	return fs
+}
+
+// errUsage is returned for bad command-line flags, which the flag package
+// has already reported along with the usage message.
+var errUsage = errors.New("invalid usage")
+
+// LoadConfig builds the configuration from the defaults, the config file
+// named by -config or SNIPPETBOX_CONFIG, the environment and args, in that
+// order. It doesn't validate it.
+func LoadConfig(name string, args []string) (*Config, error) {
+	cfg := &Config{Sources: map[string]string{}}
+	fs := cfg.flagSet(name)
+
+	// Parse the flags once to find the config file...
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		return nil, err
+	} else if err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		cfg.Sources[f.Name] = "default"
+	})
+	// Note which were given now, as setting them from the file or environment
+	// makes them look like they were too.
+	var given []string
+	fs.Visit(func(f *flag.Flag) {
+		given = append(given, f.Name)
+	})
+
+	path := cfg.ConfigFile
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	if path != "" {
+		err = cfg.loadFile(fs, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range cfg.Sources {
+		value, ok := os.LookupEnv(envName(name))
+		if !ok || name == "config" {
+			continue
+		}
+		err = fs.Set(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", envName(name), err)
+		}
+		cfg.Sources[name] = "env"
+	}
+
+	// ...then again so that they override everything else. This can't fail,
+	// as it already worked the first time.
+	fs.Parse(args)
+	for _, name := range given {
+		cfg.Sources[name] = "flag"
+	}
+
+	// Development mode relaxes the production checks, unless the environment
+	// was chosen explicitly, in which case Validate refuses the combination.
+	if cfg.Dev && cfg.Sources["env"] == "default" {
+		cfg.Env = EnvDevelopment
+	}
+
+	return cfg, nil
+}
+
+// loadFile applies the settings in the YAML file at path.
+func (cfg *Config) loadFile(fs *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]interface{}
+	err = yaml.Unmarshal(b, &settings)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name, value := range settings {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+
+		var s string
+		switch v := value.(type) {
+		case nil:
+		case string, bool, int, float64:
+			s = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("%s: setting %q must be a single value", path, name)
+		}
+
+		err = fs.Set(name, s)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		cfg.Sources[name] = "file"
+	}
+
+	return nil
+}
+
+// envName returns the environment variable for a setting.
+func envName(name string) string {
+	return "SNIPPETBOX_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Validate checks that the settings make sense, and that no placeholder
+// secrets are used in production.
+func (cfg *Config) Validate() error {
+	switch cfg.Env {
+	case EnvDevelopment, EnvProduction:
+	default:
+		return fmt.Errorf("invalid -env %q", cfg.Env)
+	}
+
+	switch cfg.SecretPolicy {
+	case SecretPolicyBlock, SecretPolicyRedact, SecretPolicyConfirm:
+	default:
+		return fmt.Errorf("invalid -secret-policy %q", cfg.SecretPolicy)
+	}
+
+	switch cfg.AccessLogFormat {
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+	default:
+		return fmt.Errorf("invalid -access-log-format %q", cfg.AccessLogFormat)
+	}
+
+	_, err := NewLogger(io.Discard, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(cfg.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -base-url %q: must be an absolute http or https URL", cfg.BaseURL)
+	}
+
+	if cfg.Env != EnvProduction {
+		return nil
+	}
+
+	if cfg.Dev {
+		return errors.New("refusing to run in production with -dev")
+	}
+
+	// Without an SMTP server emails are written to the log, and with them the
+	// tokens in verification links.
+	if cfg.SMTPAddr == "" {
+		return errors.New("refusing to run in production without -smtp-addr")
+	}
+
+	// Without a key, anyone could tell which email addresses the audit log
+	// refers to by hashing a list of them.
+	if cfg.AuditKey == "" {
+		return errors.New("refusing to run in production without -audit-key")
+	}
+
+	secrets := map[string]string{
+		"audit-key":          cfg.AuditKey,
+		"dsn":                dsnPassword(cfg.DSN),
+		"oidc-client-secret": cfg.OIDCClientSecret,
+		"smtp-password":      cfg.SMTPPassword,
+	}
+	var errs []error
+	for name, secret := range secrets {
+		for _, placeholder := range placeholderSecrets {
+			if secret != "" && strings.EqualFold(secret, placeholder) {
+				errs = append(errs, fmt.Errorf("refusing to run in production with a placeholder secret in -%s", name))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes the settings to w as YAML, in the form of a config file, noting
+// where each came from. Secrets are masked.
+func (cfg *Config) Print(w io.Writer) {
+	// Binding the flags resets the fields to their defaults, so do it on a
+	// copy and then fill that in.
+	c := &Config{}
+	fs := c.flagSet("")
+	*c = *cfg
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+
+		value := f.Value.String()
+		if secretSettings[f.Name] && value != "" {
+			if f.Name == "dsn" {
+				value = maskDSN(value)
+			} else {
+				value = "********"
+			}
+		}
+		fmt.Fprintf(w, "%s: %s # %s\n", f.Name, strconv.Quote(value), cfg.Sources[f.Name])
+	})
+}
+
+// dsnPassword returns the password in a MySQL DSN of the form
+// user:password@protocol(address)/dbname?param=value.
+func dsnPassword(dsn string) string {
+	_, password, _ := splitDSN(dsn)
+	return password
+}
+
+// maskDSN returns the DSN with its password masked.
+func maskDSN(dsn string) string {
+	start, password, end := splitDSN(dsn)
+	if password == "" {
+		return dsn
+	}
+	return start + "********" + end
+}
+
+// splitDSN splits a DSN around its password. The password may itself contain
+// "@" or "/", so look for the last "@" before the last "/".
+func splitDSN(dsn string) (start, password, end string) {
+	slash := strings.LastIndex(dsn, "/")
+	if slash < 0 {
+		return dsn, "", ""
+	}
+	at := strings.LastIndex(dsn[:slash], "@")
+	if at < 0 {
+		return dsn, "", ""
+	}
+	colon := strings.Index(dsn[:at], ":")
+	if colon < 0 {
+		return dsn, "", ""
+	}
+	return dsn[:colon+1], dsn[colon+1 : at], dsn[at:]
+}
+
+// configCommand implements `snippetbox config print [flags]`, which shows the
+// effective configuration with secrets masked, and returns the exit status.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: snippetbox config print [flags]")
+		return 2
+	}
+
+	cfg, err := LoadConfig("snippetbox config print", args[1:])
+	if err == flag.ErrHelp {
+		return 0
+	} else if err == errUsage {
+		return 2
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cfg.Print(os.Stdout)
+
+	// Still show the configuration if it's invalid, as that's when it's most
+	// useful, but say what's wrong.
+	err = cfg.Validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
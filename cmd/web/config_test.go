@@ -0,0 +1,231 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes a YAML config file into a temporary directory and
+// returns its path.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "snippetbox.yaml")
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		env        string
+		flag       string
+		wantAddr   string
+		wantSource string
+	}{
+		{"default", "", "", "", ":4000", "default"},
+		{"file", ":5000", "", "", ":5000", "file"},
+		{"env", "", ":6000", "", ":6000", "env"},
+		{"flag", "", "", ":7000", ":7000", "flag"},
+		{"env over file", ":5000", ":6000", "", ":6000", "env"},
+		{"flag over file", ":5000", "", ":7000", ":7000", "flag"},
+		{"flag over env", "", ":6000", ":7000", ":7000", "flag"},
+		{"flag over everything", ":5000", ":6000", ":7000", ":7000", "flag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.file != "" {
+				args = append(args, "-config", writeConfigFile(t, "addr: "+tt.file+"\n"))
+			}
+			if tt.env != "" {
+				t.Setenv("SNIPPETBOX_ADDR", tt.env)
+			}
+			if tt.flag != "" {
+				args = append(args, "-addr", tt.flag)
+			}
+
+			cfg, err := LoadConfig("snippetbox", args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Addr != tt.wantAddr {
+				t.Errorf("Addr = %q, want %q", cfg.Addr, tt.wantAddr)
+			}
+			if got := cfg.Sources["addr"]; got != tt.wantSource {
+				t.Errorf("Sources[addr] = %q, want %q", got, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	t.Setenv("SNIPPETBOX_CONFIG", writeConfigFile(t, "secret-policy: block\nhsts-max-age: 1h\n"))
+
+	cfg, err := LoadConfig("snippetbox", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SecretPolicy != SecretPolicyBlock {
+		t.Errorf("SecretPolicy = %q, want %q", cfg.SecretPolicy, SecretPolicyBlock)
+	}
+	if cfg.HSTSMaxAge.String() != "1h0m0s" {
+		t.Errorf("HSTSMaxAge = %s, want 1h", cfg.HSTSMaxAge)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		want string
+	}{
+		{"unknown setting", "nonsense: 1\n", nil, `unknown setting "nonsense"`},
+		{"config in file", "config: other.yaml\n", nil, `unknown setting "config"`},
+		{"list value", "addr: [a, b]\n", nil, "must be a single value"},
+		{"bad value", "drain-timeout: soon\n", nil, "drain-timeout"},
+		{"extra argument", "", []string{"extra"}, `unexpected argument "extra"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tt.file)}, args...)
+			}
+
+			_, err := LoadConfig("snippetbox", args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const goodDSN = "web:Xk8#pq2@/snippetbox?parseTime=true"
+	const smtp = "mail.example.com:587"
+	const key = "Zq3vX9pLr7"
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string // empty if the config is valid
+	}{
+		{"defaults", nil, "without -smtp-addr"},
+		{"development", []string{"-env", "development"}, ""},
+		{"dev mode", []string{"-dev"}, ""},
+		{"production", []string{"-env", "production", "-smtp-addr", smtp, "-audit-key", key, "-dsn", goodDSN}, ""},
+		{"production default DSN", []string{"-env", "production", "-smtp-addr", smtp, "-audit-key", key}, "placeholder secret in -dsn"},
+		{"production placeholder DSN password", []string{"-env", "production", "-smtp-addr", smtp, "-audit-key", key, "-dsn", "web:ChangeMe@/snippetbox"}, "placeholder secret in -dsn"},
+		{"production without SMTP", []string{"-env", "production", "-audit-key", key, "-dsn", goodDSN}, "without -smtp-addr"},
+		{"production without audit key", []string{"-env", "production", "-smtp-addr", smtp, "-dsn", goodDSN}, "without -audit-key"},
+		{"production placeholder audit key", []string{"-env", "production", "-smtp-addr", smtp, "-audit-key", "changeme", "-dsn", goodDSN}, "placeholder secret in -audit-key"},
+		{"production placeholder SMTP password", []string{"-env", "production", "-smtp-addr", smtp, "-audit-key", key, "-dsn", goodDSN, "-smtp-password", "password"}, "placeholder secret in -smtp-password"},
+		{"production placeholder OIDC secret", []string{"-env", "production", "-smtp-addr", smtp, "-audit-key", key, "-dsn", goodDSN, "-oidc-client-secret", "dev-secret"}, "placeholder secret in -oidc-client-secret"},
+		{"production dev mode", []string{"-env", "production", "-smtp-addr", smtp, "-audit-key", key, "-dsn", goodDSN, "-dev"}, "-dev"},
+		{"development placeholder", []string{"-env", "development", "-smtp-password", "password"}, ""},
+		{"dev mode placeholder", []string{"-dev", "-smtp-password", "password"}, ""},
+		{"bad env", []string{"-env", "staging"}, "invalid -env"},
+		{"bad secret policy", []string{"-secret-policy", "ignore"}, "invalid -secret-policy"},
+		{"bad access log format", []string{"-access-log-format", "xml"}, "invalid -access-log-format"},
+		{"bad log level", []string{"-log-level", "loud"}, "loud"},
+		{"relative base URL", []string{"-base-url", "example.com"}, "invalid -base-url"},
+		{"non-HTTP base URL", []string{"-base-url", "ftp://example.com"}, "invalid -base-url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig("snippetbox", tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate = %v, want nil", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDevEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		want string
+	}{
+		{"default", "", nil, EnvProduction},
+		{"dev mode", "", []string{"-dev"}, EnvDevelopment},
+		{"dev mode in file", "dev: true\n", nil, EnvDevelopment},
+		{"explicit env", "", []string{"-dev", "-env", "production"}, EnvProduction},
+		{"explicit env in file", "env: production\n", []string{"-dev"}, EnvProduction},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tt.file)}, args...)
+			}
+
+			cfg, err := LoadConfig("snippetbox", args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Env != tt.want {
+				t.Errorf("Env = %q, want %q", cfg.Env, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitDSN(t *testing.T) {
+	tests := []struct {
+		dsn                  string
+		start, password, end string
+	}{
+		{"sb:pass@/snippetbox?parseTime=true", "sb:", "pass", "@/snippetbox?parseTime=true"},
+		{"sb:p@ss@tcp(db:3306)/snippetbox", "sb:", "p@ss", "@tcp(db:3306)/snippetbox"},
+		{"sb:p/ss@tcp(db:3306)/snippetbox", "sb:", "p/ss", "@tcp(db:3306)/snippetbox"},
+		{"sb:a@b/c:d@unix(/var/run/mysqld.sock)/snippetbox", "sb:", "a@b/c:d", "@unix(/var/run/mysqld.sock)/snippetbox"},
+		{"sb:@/snippetbox", "sb:", "", "@/snippetbox"},
+		{"sb@/snippetbox", "sb@/snippetbox", "", ""},
+		{"/snippetbox", "/snippetbox", "", ""},
+		{"snippetbox", "snippetbox", "", ""},
+	}
+
+	for _, tt := range tests {
+		start, password, end := splitDSN(tt.dsn)
+		if start != tt.start || password != tt.password || end != tt.end {
+			t.Errorf("splitDSN(%q) = %q, %q, %q, want %q, %q, %q", tt.dsn, start, password, end, tt.start, tt.password, tt.end)
+		}
+	}
+}
+
+func TestMaskDSN(t *testing.T) {
+	tests := []struct {
+		dsn, want string
+	}{
+		{"sb:p@ss/word@tcp(db:3306)/snippetbox", "sb:********@tcp(db:3306)/snippetbox"},
+		{"sb@/snippetbox", "sb@/snippetbox"},
+	}
+
+	for _, tt := range tests {
+		if got := maskDSN(tt.dsn); got != tt.want {
+			t.Errorf("maskDSN(%q) = %q, want %q", tt.dsn, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vermeerp/snippetbox/pkg/devcert"
+)
+
+// devCertCommand implements `snippetbox dev-cert [flags]`, which creates a
+// local certificate authority (unless one already exists) and uses it to sign
+// a certificate for the development server. It returns the exit status.
+func devCertCommand(args []string) int {
+	flags := flag.NewFlagSet("snippetbox dev-cert", flag.ContinueOnError)
+	dir := flags.String("dir", "./tls", "Directory to write ca.pem, ca-key.pem, cert.pem and key.pem to")
+	hosts := flags.String("hosts", strings.Join(devcert.DefaultHosts, ","), "Comma-separated host names and IP addresses to issue the certificate for")
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	err = writeDevCert(*dir, strings.Split(*hosts, ","))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func writeDevCert(dir string, hosts []string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// Reuse the CA if there already is one, so that it only has to be
+	// trusted once.
+	caCert := filepath.Join(dir, "ca.pem")
+	caKey := filepath.Join(dir, "ca-key.pem")
+	ca, err := devcert.LoadCA(caCert, caKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		ca, err = devcert.NewCA()
+		if err != nil {
+			return err
+		}
+		err = ca.WriteFiles(caCert, caKey)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created a certificate authority in %s. Add it to your system or browser trust store\n", caCert)
+		fmt.Println("to stop warnings about the development server's certificate.")
+	} else if err != nil {
+		return err
+	}
+
+	for i := range hosts {
+		hosts[i] = strings.TrimSpace(hosts[i])
+	}
+	certPEM, keyPEM, err := ca.Issue(hosts)
+	if err != nil {
+		return err
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, certPEM, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(keyFile, keyPEM, 0600)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %s and %s for %s.\n", certFile, keyFile, strings.Join(hosts, ", "))
+	return nil
+}
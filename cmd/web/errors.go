@@ -1,28 +1,70 @@
 package main
 
 import (
-	"log"
+	"encoding/json"
 	"net/http"
 	"runtime/debug"
+	"strings"
 )
 
 // ServerError helper writes an error message and stack trace to the log, then
 // sends a generic 500 Internal Server Error response to the user.
-func (app *App) ServerError(w http.ResponseWriter, err error) {
-	log.Printf("%s\n%s", err.Error(), debug.Stack())
-	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+func (app *App) ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.Logger.ErrorContext(r.Context(), err.Error(), "stack", string(debug.Stack()))
+	app.renderError(w, r, http.StatusInternalServerError)
 }
 
 // ClientError helper sends a specific status code and corresponding description
 // to the user. We'll use this later in the book to send responses like 400 "Bad
 // Request" when there's a problem with the request that the user sent.
-func (app *App) ClientError(w http.ResponseWriter, status int) {
-	http.Error(w, http.StatusText(status), status)
+func (app *App) ClientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.renderError(w, r, status)
 }
 
 // NotFound - For consistency, we'll also implement a NotFound helper. This is simply a
 // convenience wrapper around ClientError which sends a 404 Not Found response to
 // the user.
-func (app *App) NotFound(w http.ResponseWriter) {
-	app.ClientError(w, http.StatusNotFound)
+func (app *App) NotFound(w http.ResponseWriter, r *http.Request) {
+	app.ClientError(w, r, http.StatusNotFound)
+}
+
+// renderError sends an error page, or a JSON error to API clients. If the page
+// can't be rendered, perhaps because the error was the database going away, a
+// plain text one is sent instead.
+func (app *App) renderError(w http.ResponseWriter, r *http.Request, status int) {
+	id := requestID(r.Context())
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(struct {
+			Status    int    `json:"status"`
+			Error     string `json:"error"`
+			RequestID string `json:"request_id,omitempty"`
+		}{status, http.StatusText(status), id})
+		return
+	}
+
+	data := &HTMLData{ErrorStatus: status}
+	if status >= http.StatusInternalServerError {
+		data.RequestID = id
+	}
+
+	err := app.render(w, r, status, "error.page.html", data)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "rendering error page", "error", err)
+
+		msg := http.StatusText(status)
+		if data.RequestID != "" {
+			msg += " (request ID " + data.RequestID + ")"
+		}
+		http.Error(w, msg, status)
+	}
+}
+
+// wantsJSON reports whether the client would rather have JSON than HTML.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
 }
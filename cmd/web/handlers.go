@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/vermeerp/snippetbox/pkg/forms"
 	"github.com/vermeerp/snippetbox/pkg/models"
@@ -16,7 +17,7 @@ func (app *App) Home(w http.ResponseWriter, r *http.Request) {
 	// Fetch a slice of the latest snippets from the database.
 	snippets, err := app.Database.LatestSnippets()
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
@@ -30,24 +31,24 @@ func (app *App) Home(w http.ResponseWriter, r *http.Request) {
 func (app *App) ShowSnippet(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
 	if err != nil || id < 1 {
-		app.NotFound(w)
+		app.NotFound(w, r)
 		return
 	}
 
 	snippet, err := app.Database.GetSnippet(id)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 	if snippet == nil {
-		app.NotFound(w)
+		app.NotFound(w, r)
 		return
 	}
 
 	session := app.Sessions.Load(r)
 	flash, err := session.PopString(w, "flash")
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
@@ -75,16 +76,17 @@ func (app *App) CreateSnippet(w http.ResponseWriter, r *http.Request) {
 	// app.ClientError helper to send a 400 Bad Request response to the user.
 	err := r.ParseForm()
 	if err != nil {
-		app.ClientError(w, http.StatusBadRequest)
+		app.ClientError(w, r, http.StatusBadRequest)
 		return
 	}
 
 	// We initialize a *forms.NewSnippet object and use the r.PostForm.Get() method
 	// to assign the data to the relevant fields.
 	form := &forms.NewSnippet{
-		Title:   r.PostForm.Get("title"),
-		Content: r.PostForm.Get("content"),
-		Expires: r.PostForm.Get("expires"),
+		Title:          r.PostForm.Get("title"),
+		Content:        r.PostForm.Get("content"),
+		Expires:        r.PostForm.Get("expires"),
+		ConfirmSecrets: r.PostForm.Get("confirm_secrets") != "",
 	}
 
 	// Check if the form passes the validation checks. If not, then use the
@@ -94,12 +96,15 @@ func (app *App) CreateSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If the validation checks have been passed, call our database model's
-	// InsertSnippet() method to create a new database record and return it's ID
-	// value.
-	id, err := app.Database.InsertSnippet(form.Title, form.Content, form.Expires)
-	if err != nil {
-		app.ServerError(w, err)
+	// Look for credentials in the content. Depending on the policy, these are
+	// redacted, or the author is warned and the snippet isn't saved.
+	findings, ok := app.ScanSnippet(form)
+	if !ok {
+		app.RenderHTML(w, r, "new.page.html", &HTMLData{
+			Findings:     findings,
+			Form:         form,
+			SecretPolicy: app.SecretPolicy,
+		})
 		return
 	}
 
@@ -109,12 +114,34 @@ func (app *App) CreateSnippet(w http.ResponseWriter, r *http.Request) {
 	// are deferred until the session is actually used.
 	session := app.Sessions.Load(r)
 
+	// The snippet is owned by the logged in user.
+	userID, err := session.GetInt("currentUserID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	// If the validation checks have been passed, call our database model's
+	// InsertSnippet() method to create a new database record and return it's ID
+	// value.
+	id, err := app.Database.InsertSnippet(userID, form.Title, form.Content, form.Expires)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, userID, "snippet.create", snippetTarget(id), "")
+	app.Metrics.SnippetsCreated.Inc()
+
 	// Use the PutString() method to add a string value ("Your snippet was saved
 	// successfully!") and the corresponding key ("flash") to the the session
 	// data.
-	err = session.PutString(w, "flash", "Your snippet was saved successfully!")
+	msg := "Your snippet was saved successfully!"
+	if len(findings) > 0 && app.SecretPolicy == SecretPolicyRedact {
+		msg = fmt.Sprintf("Your snippet was saved successfully, with %d possible secrets redacted.", len(findings))
+	}
+	err = session.PutString(w, "flash", msg)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
@@ -134,7 +161,7 @@ func (app *App) SignupUser(w http.ResponseWriter, r *http.Request) {
 func (app *App) CreateUser(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		app.ClientError(w, http.StatusBadRequest)
+		app.ClientError(w, r, http.StatusBadRequest)
 		return
 	}
 
@@ -151,23 +178,31 @@ func (app *App) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	// Try to create a new user record in the database. If the email already exists
 	// add a failure message to the form and re-display the form.
-	err = app.Database.InsertUser(form.Name, form.Email, form.Password)
+	id, err := app.Database.InsertUser(form.Name, form.Email, form.Password)
 	if err == models.ErrDuplicateEmail {
 		form.Failures["Email"] = "Address is already in use"
 		app.RenderHTML(w, r, "signup.page.html", &HTMLData{Form: form})
 		return
 	} else if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
+	app.audit(r, id, "user.signup", userTarget(id), "")
+
+	// Send the new user a verification link. A delivery failure shouldn't fail
+	// the signup, as the user can ask for another link once they've logged in.
+	err = app.sendVerification(id, form.Email)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "sending verification email", "user_id", id, "error", err)
+	}
 
 	// Otherwise, add a confirmation flash message to the session confirming that
 	// their signup worked and asking them to log in.
-	msg := "Your signup was successful. Please log in using your credentials."
+	msg := "Your signup was successful. We've sent you an email to verify your address. Please log in using your credentials."
 	session := app.Sessions.Load(r)
 	err = session.PutString(w, "flash", msg)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
@@ -180,7 +215,7 @@ func (app *App) LoginUser(w http.ResponseWriter, r *http.Request) {
 	session := app.Sessions.Load(r)
 	flash, err := session.PopString(w, "flash")
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
@@ -194,7 +229,7 @@ func (app *App) LoginUser(w http.ResponseWriter, r *http.Request) {
 func (app *App) VerifyUser(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		app.ClientError(w, http.StatusBadRequest)
+		app.ClientError(w, r, http.StatusBadRequest)
 		return
 	}
 
@@ -208,41 +243,211 @@ func (app *App) VerifyUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Don't even check the password if there have been too many failed attempts
+	// for this account or from this client recently.
+	locked, err := app.loginLocked(r, form.Email)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if locked {
+		app.audit(r, 0, "login.failure", app.emailTarget(form.Email), "locked out")
+		app.countLogin(false)
+		form.Failures["Generic"] = lockedOutMessage
+		app.RenderHTML(w, r, "login.page.html", &HTMLData{Form: form})
+		return
+	}
+
 	// Check whether the credentials are valid. If they're not, add a generic error
 	// message to the form failures map, and re-display the login page.
 	currentUserID, err := app.Database.VerifyUser(form.Email, form.Password)
 	if err == models.ErrInvalidCredentials {
+		app.audit(r, 0, "login.failure", app.emailTarget(form.Email), "invalid credentials")
+		app.countLogin(false)
+		err = app.loginFailed(r, form.Email)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+
 		form.Failures["Generic"] = "Email or Password is incorrect"
 		app.RenderHTML(w, r, "login.page.html", &HTMLData{Form: form})
 		return
+	} else if err == models.ErrAccountDisabled {
+		app.audit(r, 0, "login.failure", app.emailTarget(form.Email), "account disabled")
+		app.countLogin(false)
+		form.Failures["Generic"] = "Your account has been disabled"
+		app.RenderHTML(w, r, "login.page.html", &HTMLData{Form: form})
+		return
 	} else if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
-	// Add the ID of the current user to the session, so that they are now 'logged
-	// in'.
-	session := app.Sessions.Load(r)
-	err = session.PutInt(w, "currentUserID", currentUserID)
+	user, err := app.Database.GetUser(currentUserID)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
+	// Users with two-factor authentication aren't logged in yet. Remember who
+	// they are and ask for their one-time code.
+	if user.TOTPEnabled {
+		err = app.startTOTPLogin(w, r, currentUserID)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/user/login/totp", http.StatusSeeOther)
+		return
+	}
+
+	err = app.loginSucceeded(form.Email)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.logIn(w, r, currentUserID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, currentUserID, "login.success", userTarget(currentUserID), "password")
+	app.countLogin(true)
+
 	// Redirect the user to the Add Snippet page.
 	http.Redirect(w, r, "/snippet/new", http.StatusSeeOther)
 }
 
 // LogoutUser logs out a user
 func (app *App) LogoutUser(w http.ResponseWriter, r *http.Request) {
-	// Remove the currentUserID from the session data.
+	// Revoke the session and destroy its data, so that the session cookie is
+	// useless even if it has been stolen.
 	session := app.Sessions.Load(r)
-	err := session.Remove(w, "currentUserID")
+	token, err := session.GetString("sessionID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.DeleteSessionToken(token)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, app.currentUserID(r), "logout", userTarget(app.currentUserID(r)), "")
+
+	err = session.Destroy(w)
+	if err != nil {
+		app.ServerError(w, r, err)
 		return
 	}
 
 	// Redirect the user to the homepage.
 	http.Redirect(w, r, "/", 303)
 }
+
+// verificationResendInterval is the minimum time between two verification
+// emails being sent to the same user.
+const verificationResendInterval = 5 * time.Minute
+
+// sendVerification creates a new verification token for the user and emails
+// them a link containing it. The link is built from the configured base URL,
+// never from the request, whose Host header the client controls.
+func (app *App) sendVerification(userID int, email string) error {
+	token, err := app.Database.CreateVerificationToken(userID)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/user/verify/%s", app.BaseURL, token)
+	body := fmt.Sprintf("Please confirm your email address by visiting the link below within 24 hours.\n\n%s\n", link)
+	return app.Mailer.Send(email, "Verify your Snippetbox email address", body)
+}
+
+// ShowVerification displays the verification status of the current user, with
+// an option to resend the verification email.
+func (app *App) ShowVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "verify.page.html", &HTMLData{
+		Flash: flash,
+		User:  user,
+	})
+}
+
+// ResendVerification sends the current user a fresh verification link, unless
+// one was sent very recently.
+func (app *App) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if user == nil {
+		app.ClientError(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	var msg string
+	switch {
+	case user.Verified:
+		msg = "Your email address is already verified."
+	case time.Since(user.VerificationSent) < verificationResendInterval:
+		msg = "A verification email was sent recently. Please wait a few minutes before asking for another."
+	default:
+		err = app.sendVerification(user.ID, user.Email)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+		msg = "A new verification email is on its way."
+	}
+
+	session := app.Sessions.Load(r)
+	err = session.PutString(w, "flash", msg)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+}
+
+// VerifyEmail handles the link sent in the verification email.
+func (app *App) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := app.Database.VerifyEmail(r.URL.Query().Get(":token"))
+	if err == models.ErrInvalidToken {
+		app.RenderHTML(w, r, "verify.page.html", &HTMLData{
+			Flash: "This verification link is invalid or has expired.",
+		})
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.Logger.InfoContext(r.Context(), "email address verified", "user_id", id)
+	app.audit(r, id, "user.verify_email", userTarget(id), "")
+
+	session := app.Sessions.Load(r)
+	err = session.PutString(w, "flash", "Your email address has been verified.")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/vermeerp/snippetbox/migrations"
+)
+
+// healthCheck is the result of a single readiness check. The probes are
+// public, so why a check failed is only logged.
+type healthCheck struct {
+	Status string `json:"status"` // "ok" or "error"
+}
+
+// healthReport is the body of /healthz and /readyz responses.
+type healthReport struct {
+	Status string                  `json:"status"` // "ok" or "unavailable"
+	Checks map[string]*healthCheck `json:"checks,omitempty"`
+}
+
+// Healthz reports that the process is up and serving requests. It doesn't
+// look at any dependencies, so that a database outage doesn't get the server
+// restarted.
+func (app *App) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, &healthReport{Status: "ok"})
+}
+
+// Readyz reports whether the server can usefully handle requests: the database
+// is reachable, the templates parse and the schema is up to date. The checks
+// must finish within ReadyTimeout. Once the server starts shutting down it is
+// never ready again.
+func (app *App) Readyz(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		writeHealth(w, &healthReport{
+			Status: "unavailable",
+			Checks: map[string]*healthCheck{
+				"shutdown": {Status: "error"},
+			},
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.ReadyTimeout)
+	defer cancel()
+
+	report := &healthReport{
+		Status: "ok",
+		Checks: map[string]*healthCheck{
+			"database":   app.healthCheck(r, "database", app.Database.PingContext(ctx)),
+			"templates":  app.healthCheck(r, "templates", app.checkTemplates()),
+			"migrations": app.healthCheck(r, "migrations", app.checkMigrations(ctx)),
+		},
+	}
+	for _, check := range report.Checks {
+		if check.Status != "ok" {
+			report.Status = "unavailable"
+		}
+	}
+
+	writeHealth(w, report)
+}
+
+// healthCheck returns the result of the named check, logging the error if it
+// failed.
+func (app *App) healthCheck(r *http.Request, name string, err error) *healthCheck {
+	if err != nil {
+		app.Logger.WarnContext(r.Context(), "readiness check failed", "check", name, "error", err)
+		return &healthCheck{Status: "error"}
+	}
+	return &healthCheck{Status: "ok"}
+}
+
+// checkTemplates checks that the templates were parsed. They always are at
+// startup, but in development mode a later reload may have failed.
+func (app *App) checkTemplates() error {
+	return app.Templates.Err()
+}
+
+// checkMigrations checks that the newest migration has been applied.
+func (app *App) checkMigrations(ctx context.Context) error {
+	want, err := migrations.Latest()
+	if err != nil {
+		return err
+	}
+
+	got, err := app.Database.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("schema is at version %d, want %d", got, want)
+	}
+
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, report *healthReport) {
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"net"
 	"net/http"
+
+	"github.com/vermeerp/snippetbox/pkg/models"
 )
 
 // LoggedIn returns whether a user is logged in or not
@@ -17,3 +22,91 @@ func (app *App) LoggedIn(r *http.Request) (bool, error) {
 
 	return loggedIn, nil
 }
+
+// currentUserID returns the ID of the logged in user, or 0 if nobody is logged
+// in.
+func (app *App) currentUserID(r *http.Request) int {
+	session := app.Sessions.Load(r)
+	id, err := session.GetInt("currentUserID")
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// CurrentUser returns the logged in user for the request, or nil if nobody is
+// logged in.
+func (app *App) CurrentUser(r *http.Request) (*models.User, error) {
+	session := app.Sessions.Load(r)
+	id, err := session.GetInt("currentUserID")
+	if err != nil || id == 0 {
+		return nil, err
+	}
+
+	return app.Database.GetUser(id)
+}
+
+// logIn adds the ID of the user to the session, so that they are now 'logged
+// in', and records the session so it shows up on the active sessions page.
+func (app *App) logIn(w http.ResponseWriter, r *http.Request, userID int) error {
+	session := app.Sessions.Load(r)
+
+	// Change the session token whenever the user's privilege level changes, to
+	// prevent session fixation attacks.
+	err := session.RenewToken(w)
+	if err != nil {
+		return err
+	}
+
+	// Don't let a previous user's reauthentication carry over.
+	err = session.Remove(w, "reauthTime")
+	if err != nil {
+		return err
+	}
+
+	token, err := app.Database.CreateSession(userID, clientIP(r), r.UserAgent())
+	if err != nil {
+		return err
+	}
+
+	err = session.PutString(w, "sessionID", token)
+	if err != nil {
+		return err
+	}
+
+	return session.PutInt(w, "currentUserID", userID)
+}
+
+// clientIP returns the IP address of the client that made the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// randomToken returns a random, URL-safe string suitable for use as a one-off
+// token such as an OAuth2 state parameter.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// redirectWithFlash adds a flash message to the session and redirects the user
+// to the given URL, where it will be displayed.
+func (app *App) redirectWithFlash(w http.ResponseWriter, r *http.Request, url, msg string) {
+	session := app.Sessions.Load(r)
+	err := session.PutString(w, "flash", msg)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
@@ -1,63 +1,266 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/mysqlstore"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/vermeerp/snippetbox/pkg/devcert"
+	"github.com/vermeerp/snippetbox/pkg/logfile"
+	"github.com/vermeerp/snippetbox/pkg/mailer"
 	"github.com/vermeerp/snippetbox/pkg/models"
+	"github.com/vermeerp/snippetbox/pkg/overlayfs"
+	"github.com/vermeerp/snippetbox/ui"
 )
 
 func main() {
 
-	// Define command-line flags for the network address and location of the static
-	// files directory.
-	addr := flag.String("addr", ":4000", "HTTP network address")
-	dsn := flag.String("dsn", "sb:u4UHCQQs#Agoqgi@/snippetbox?parseTime=true", "MySQL DSN")
-	htmlDir := flag.String("html-dir", "./ui/html", "Path to HTML templates")
-	secret := flag.String("secret", "s6Nd%+pPbnzHbS*+9Pk8qGWhTzbpa@ge", "Secret key")
-	staticDir := flag.String("static-dir", "./ui/static", "Path to static assets")
-	tlsCert := flag.String("tls-cert", "./tls/cert.pem", "Path to TLS certificate")
-	tlsKey := flag.String("tls-key", "./tls/key.pem", "Path to TLS key")
+	// Subcommands come before any flags.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		case "dev-cert":
+			os.Exit(devCertCommand(os.Args[2:]))
+		}
+	}
+
+	cfg, err := LoadConfig(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err == errUsage {
+		os.Exit(2)
+	} else if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := NewLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// Send anything still using the log package, such as the mailer, through
+	// the same logger.
+	slog.SetDefault(logger)
 
-	flag.Parse()
+	err = run(cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger.Info("server stopped")
+}
 
-	db := connect(*dsn)
+// run starts the server with the given configuration and runs it until it is
+// told to stop or fails. Everything it opens is closed before it returns, so
+// that the access log is flushed even if the server couldn't start.
+func run(cfg *Config, logger *slog.Logger) error {
+	var accessLogOut io.Writer = os.Stdout
+	if cfg.AccessLog != "" {
+		f, err := logfile.Open(cfg.AccessLog, cfg.AccessLogMaxSize<<20, cfg.AccessLogMaxBackups)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		accessLogOut = f
+	}
+
+	db, err := connect(cfg.DSN)
+	if err != nil {
+		return err
+	}
 	defer db.Close()
 
-	sessionManager := scs.NewCookieManager(*secret)
-	sessionManager.Lifetime(12 * time.Hour)
+	// Keep session data in the database rather than in the cookie, so that
+	// sessions can be revoked. Expired sessions are cleaned up every 5 minutes.
+	sessionStore := mysqlstore.New(db, 5*time.Minute)
+	defer sessionStore.StopCleanup()
+	sessionManager := scs.NewManager(sessionStore)
+	sessionManager.Lifetime(sessionLifetime)
 	sessionManager.Persist(true)
+	sessionManager.Secure(true)
+
+	// Use the SMTP mailer if a server has been configured, otherwise just log
+	// outgoing emails.
+	var m mailer.Mailer = &mailer.LogMailer{}
+	if cfg.SMTPAddr != "" {
+		m = &mailer.SMTPMailer{
+			Addr:     cfg.SMTPAddr,
+			From:     cfg.SMTPFrom,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+		}
+	}
+
+	// Discover the OpenID Connect provider, if one has been configured.
+	var oidcConfig *OIDC
+	if cfg.OIDCIssuer != "" {
+		oidcConfig, err = NewOIDC(context.Background(), cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Load the TLS certificate, which can later be replaced without a
+	// restart. In development mode, make one up if there isn't one.
+	certs, err := NewCertReloader(cfg.TLSCert, cfg.TLSKey, logger)
+	if cfg.Dev && errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("no TLS certificate found, using a temporary one; run `snippetbox dev-cert` to create one browsers can trust")
+		var cert tls.Certificate
+		cert, err = devcert.Ephemeral(devcert.DefaultHosts)
+		if err == nil {
+			certs, err = NewStaticCert(cert, logger)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// The UI is built in, but files in -html-dir and -static-dir take the
+	// place of the built-in ones with the same names.
+	htmlFS, err := uiFS("html", cfg.HTMLDir)
+	if err != nil {
+		return err
+	}
+	staticFS, err := uiFS("static", cfg.StaticDir)
+	if err != nil {
+		return err
+	}
+	static := &Static{FS: staticFS, NoCache: cfg.Dev}
+
+	// Parse the templates up front, so that mistakes in them stop the server
+	// from starting.
+	templates, err := NewTemplates(htmlFS, templateFuncs(static), logger)
+	if err != nil {
+		return err
+	}
+
+	// Load the client certificate settings, if they're used.
+	var clientCerts *ClientCerts
+	if cfg.ClientCA != "" {
+		clientCerts, err = LoadClientCerts(cfg.ClientCA, cfg.ClientCertMap, clientCertRoutes(cfg.ClientCertRoutes))
+		if err != nil {
+			return err
+		}
+	}
 
 	// Initialize a new instance of App containing the dependencies.
 	app := &App{
-		Addr:      *addr,
-		Database:  &models.Database{DB: db},
-		HTMLDir:   *htmlDir,
-		Sessions:  sessionManager,
-		StaticDir: *staticDir,
-		TLSCert:   *tlsCert,
-		TLSKey:    *tlsKey,
+		AccessLog:     &AccessLog{Format: cfg.AccessLogFormat, Out: accessLogOut},
+		Addr:          cfg.Addr,
+		AuditKey:      []byte(cfg.AuditKey),
+		BaseURL:       strings.TrimSuffix(cfg.BaseURL, "/"),
+		Certs:         certs,
+		ClientCerts:   clientCerts,
+		Database:      &models.Database{DB: db},
+		HSTSMaxAge:    cfg.HSTSMaxAge,
+		HTTPAddr:      cfg.HTTPAddr,
+		Logger:        logger,
+		Mailer:        m,
+		Metrics:       NewMetrics(db),
+		MetricsAddr:   cfg.MetricsAddr,
+		OIDC:          oidcConfig,
+		DrainTimeout:  cfg.DrainTimeout,
+		ReadyTimeout:  cfg.ReadyTimeout,
+		SecretPolicy:  cfg.SecretPolicy,
+		ShutdownDelay: cfg.ShutdownDelay,
+		Sessions:      sessionManager,
+		Static:        static,
+		Templates:     templates,
 	}
 
-	app.RunServer()
+	// Shut down gracefully on SIGINT or SIGTERM. Once shutdown has started,
+	// a second signal kills the process straight away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Start the background workers.
+	var workers sync.WaitGroup
+	workers.Add(3)
+
+	// Remove accounts whose deletion cooldown has passed.
+	go func() {
+		defer workers.Done()
+		app.PurgeAccounts(ctx, time.Hour)
+	}()
 
+	// Forget expired sessions, as often as the session store cleans up.
+	go func() {
+		defer workers.Done()
+		app.PurgeSessions(ctx, 5*time.Minute)
+	}()
+
+	// Pick up renewed TLS certificates.
+	go func() {
+		defer workers.Done()
+		certs.Watch(ctx, cfg.TLSReloadInterval)
+	}()
+
+	// In development, pick up changes to the templates straight away.
+	if cfg.Dev {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			templates.Watch(ctx, time.Second)
+		}()
+	}
+
+	err = app.RunServer(ctx)
+
+	// Stop the background workers before the database is closed.
+	stop()
+	workers.Wait()
+
+	return err
 }
 
 // The connect() function wraps sql.Open() and returns a sql.DB connection pool
 // for a given DSN.
-func connect(dsn string) *sql.DB {
+func connect(dsn string) (*sql.DB, error) {
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	if err := db.Ping(); err != nil {
-		log.Fatal(err)
+		db.Close()
+		return nil, err
 	}
 
-	return db
+	return db, nil
+}
+
+// uiFS returns the built-in UI directory with the given name, overlaid by the
+// files in dir if it isn't empty.
+func uiFS(name, dir string) (fs.FS, error) {
+	builtIn, err := fs.Sub(ui.Files, name)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return builtIn, nil
+	}
+	return overlayfs.New(os.DirFS(dir), builtIn), nil
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bmizerany/pat"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus metrics exported on -metrics-addr.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	Requests        *prometheus.CounterVec
+	RequestDuration *prometheus.HistogramVec
+	RenderDuration  *prometheus.HistogramVec
+	SnippetsCreated prometheus.Counter
+	Logins          *prometheus.CounterVec
+}
+
+// NewMetrics registers the application's metrics, along with the Go runtime,
+// process and database pool statistics.
+func NewMetrics(db *sql.DB) *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_http_requests_total",
+			Help: "HTTP requests handled, by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route pattern and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		RenderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_template_render_duration_seconds",
+			Help:    "Time taken to render HTML pages, by template.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+		}, []string{"page"}),
+		SnippetsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_snippets_created_total",
+			Help: "Snippets created.",
+		}),
+		Logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_logins_total",
+			Help: "Login attempts, by result (success or failure).",
+		}, []string{"result"}),
+	}
+
+	m.Registry.MustRegister(
+		m.Requests,
+		m.RequestDuration,
+		m.RenderDuration,
+		m.SnippetsCreated,
+		m.Logins,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "snippetbox"),
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// countLogin records the result of a login attempt.
+func (app *App) countLogin(ok bool) {
+	result := "failure"
+	if ok {
+		result = "success"
+	}
+	app.Metrics.Logins.WithLabelValues(result).Inc()
+}
+
+const routeKey = contextKey("route")
+
+// Instrument records the count and latency of requests, labelled with the
+// pattern of the route which handled them rather than the path, so that the
+// number of series stays bounded. Requests which match no route are labelled
+// "none".
+func (app *App) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		// The router fills this in once it has matched the request.
+		route := "none"
+		ctx := context.WithValue(r.Context(), routeKey, &route)
+
+		// Requests are counted even if the handler panics.
+		completed := false
+		defer func() {
+			status := finalStatus(rec.status, completed)
+			app.Metrics.Requests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+			app.Metrics.RequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		}()
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		completed = true
+	})
+}
+
+// router wraps pat's mux to note which route pattern matched each request,
+// for Instrument.
+type router struct {
+	*pat.PatternServeMux
+}
+
+func (rt router) Get(pattern string, h http.Handler) {
+	rt.PatternServeMux.Get(pattern, markRoute(pattern, h))
+}
+
+func (rt router) Post(pattern string, h http.Handler) {
+	rt.PatternServeMux.Post(pattern, markRoute(pattern, h))
+}
+
+func markRoute(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey).(*string); ok {
+			*route = pattern
+		}
+		h.ServeHTTP(w, r)
+	})
+}
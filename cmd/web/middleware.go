@@ -1,29 +1,172 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/vermeerp/snippetbox/pkg/models"
 )
 
-// LogRequest logs requests
-func LogRequest(next http.Handler) http.Handler {
+// RequestID tags each request with an ID, which is added to every log line
+// written while handling it and returned in the X-Request-ID header. An ID set
+// by a proxy in front of us is reused so that their logs can be matched up.
+func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		pattern := `%s - "%s %s %s"`
-		log.Printf(pattern, r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
+}
+
+// RecoverPanic turns a panic in a handler into a 500 error page, logging the
+// stack, instead of letting net/http drop the connection with no response.
+// The connection is closed afterwards, as the panic may have left it in an
+// unknown state. If the handler had already started its response it's too
+// late for an error page, so the panic is logged and the response aborted.
+// It must be used inside LogRequest so that the 500 is logged.
+func (app *App) RecoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
 
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// ErrAbortHandler is how handlers deliberately abort a response.
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			if rec.status != 0 {
+				app.Logger.ErrorContext(r.Context(), fmt.Sprintf("panic: %v", err), "stack", string(debug.Stack()))
+				panic(http.ErrAbortHandler)
+			}
+
+			w.Header().Set("Connection", "close")
+			app.ServerError(w, r, fmt.Errorf("panic: %v", err))
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
 }
 
-// SecureHeaders sets headers for security features
-func SecureHeaders(next http.Handler) http.Handler {
+// LogRequest writes a line to the access log once each request has been
+// handled, recording the status, response size and latency. Requests whose
+// handler panicked are logged too, as they unwind, with a 500 if no response
+// had been started. It must be used inside RequestID.
+func (app *App) LogRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		// Take the URI now, as pat adds the route parameters to the query.
+		uri := r.URL.RequestURI()
+
+		completed := false
+		defer func() {
+			rec.status = finalStatus(rec.status, completed)
+
+			err := app.AccessLog.write(&accessLogEntry{
+				Time:      start,
+				RequestID: requestID(r.Context()),
+				RemoteIP:  clientIP(r),
+				Method:    r.Method,
+				URI:       uri,
+				Proto:     r.Proto,
+				Status:    rec.status,
+				Size:      rec.size,
+				Duration:  float64(time.Since(start).Microseconds()) / 1000,
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+			})
+			if err != nil {
+				app.Logger.ErrorContext(r.Context(), "writing access log", "error", err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+		completed = true
+	})
+}
+
+// finalStatus returns the status a response ended up with, given the one its
+// handler sent (0 if none) and whether the handler returned rather than
+// panicking. A handler which writes nothing at all sends a 200, unless it
+// panicked, in which case the client gets an error or a dropped connection.
+func finalStatus(status int, completed bool) int {
+	if status != 0 {
+		return status
+	}
+	if !completed {
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+// SecureHeaders sets headers for security features. Over TLS, it also tells
+// browsers to only use HTTPS for the next HSTSMaxAge.
+func (app *App) SecureHeaders(next http.Handler) http.Handler {
+	hsts := fmt.Sprintf("max-age=%d", int(app.HSTSMaxAge.Seconds()))
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
 		w.Header()["X-XSS-Protection"] = []string{"1; mode=block"}
+		if r.TLS != nil && app.HSTSMaxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", hsts)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Authenticate checks that the session of a logged in user hasn't been revoked
+// from the active sessions page, and logs the user out if it has.
+func (app *App) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Static files don't care who is logged in, so don't bother the
+		// database for them.
+		if strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		loggedIn, err := app.LoggedIn(r)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+		if !loggedIn {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session := app.Sessions.Load(r)
+		token, err := session.GetString("sessionID")
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+
+		err = app.Database.TouchSession(token, clientIP(r))
+		if err == models.ErrNoRecord {
+			err = session.Destroy(w)
+		}
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -35,7 +178,7 @@ func (app *App) RequireLogin(next http.Handler) http.Handler {
 		// Call the app.LoggedIn() helper to get the status for the current user.
 		loggedIn, err := app.LoggedIn(r)
 		if err != nil {
-			app.ServerError(w, err)
+			app.ServerError(w, r, err)
 			return
 		}
 
@@ -52,15 +195,59 @@ func (app *App) RequireLogin(next http.Handler) http.Handler {
 	})
 }
 
+// RequireRole guards routes that should only be accessed by users with the
+// given role (or a more privileged one). It must be used inside RequireLogin.
+func (app *App) RequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.CurrentUser(r)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+
+		if user == nil || !user.HasRole(role) {
+			app.ClientError(w, r, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireVerified guards routes that should only be accessed by users who have
+// verified their email address. It must be used inside RequireLogin.
+func (app *App) RequireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.CurrentUser(r)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+
+		// Unverified users are sent to the verification page, where they can
+		// ask for a new link.
+		if user == nil || !user.Verified {
+			http.Redirect(w, r, "/user/verify", 302)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // NoSurf middleware function which uses a customized CSRF cookie with
-// the Secure, Path and HttpOnly flags set.
-func NoSurf(next http.HandlerFunc) http.Handler {
+// the Secure, Path and HttpOnly flags set, and sends the 400 error page when
+// the check fails.
+func (app *App) NoSurf(next http.HandlerFunc) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
 		Secure:   true,
 	})
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.ClientError(w, r, http.StatusBadRequest)
+	}))
 
 	return csrfHandler
 }
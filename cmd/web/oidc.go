@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/vermeerp/snippetbox/pkg/forms"
+	"github.com/vermeerp/snippetbox/pkg/models"
+	"golang.org/x/oauth2"
+)
+
+// OIDC holds the configuration for logging in through an OpenID Connect
+// identity provider.
+type OIDC struct {
+	Issuer   string
+	OAuth2   *oauth2.Config
+	Verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDC fetches the discovery document of the issuer and returns an OIDC
+// configured for it. The provider's signing keys are fetched from its JWKS
+// endpoint when the first ID token is verified.
+func NewOIDC(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*OIDC, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDC{
+		Issuer: issuer,
+		OAuth2: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		Verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// reauthWindow is how long after confirming who they are through single
+// sign-on a user can make changes which would otherwise need their password.
+const reauthWindow = 5 * time.Minute
+
+// reauthPages are the pages which users can come back to after confirming who
+// they are through single sign-on, keyed by the paths they are linked from.
+var reauthPages = map[string]string{
+	"/user/settings/delete":   "/user/settings/delete",
+	"/user/settings/email":    "/user/settings/email",
+	"/user/settings/password": "/user/settings/password",
+	"/user/totp":              "/user/totp",
+	"/user/totp/disable":      "/user/totp",
+}
+
+// LoginOIDC starts the authorization code flow by redirecting the user to the
+// identity provider.
+func (app *App) LoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.NotFound(w, r)
+		return
+	}
+
+	app.redirectToOIDC(w, r)
+}
+
+// ReauthOIDC sends a logged in user to the identity provider to confirm who
+// they are, in place of entering their password. Users who signed up through
+// single sign-on have a random password which they don't know, so this is
+// the only way for them to make such changes. They are sent back to the page
+// given by the "next" parameter.
+func (app *App) ReauthOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.NotFound(w, r)
+		return
+	}
+
+	next, ok := reauthPages[r.URL.Query().Get("next")]
+	if !ok {
+		next = "/user/settings"
+	}
+
+	session := app.Sessions.Load(r)
+	err := session.PutString(w, "oidcReauth", next)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.redirectToOIDC(w, r)
+}
+
+// ConfirmLinkOIDC renders the form for linking single sign-on to the current
+// user's account.
+func (app *App) ConfirmLinkOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.NotFound(w, r)
+		return
+	}
+
+	app.RenderHTML(w, r, "settings-sso.page.html", &HTMLData{
+		Form: &forms.ConfirmPassword{},
+	})
+}
+
+// LinkOIDC sends a logged in user to the identity provider to link their
+// identity there to their account, after checking their password. Users whose
+// email address isn't verified must do this to log in through the provider,
+// as it can't be linked to them automatically.
+func (app *App) LinkOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	form := &forms.ConfirmPassword{
+		Password: r.PostForm.Get("password"),
+	}
+
+	if !form.Valid() {
+		app.RenderHTML(w, r, "settings-sso.page.html", &HTMLData{Form: form})
+		return
+	}
+
+	err = app.Database.VerifyPassword(user.ID, form.Password)
+	if err == models.ErrInvalidCredentials {
+		form.Failures["Password"] = "Password is incorrect"
+		app.RenderHTML(w, r, "settings-sso.page.html", &HTMLData{Form: form})
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	err = session.PutInt(w, "oidcLink", user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.redirectToOIDC(w, r)
+}
+
+// redirectToOIDC starts the authorization code flow by redirecting the user to
+// the identity provider. The state, nonce and PKCE verifier are kept in the
+// session so they can be checked when the user comes back.
+func (app *App) redirectToOIDC(w http.ResponseWriter, r *http.Request) {
+	state, err := randomToken()
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	session := app.Sessions.Load(r)
+	for key, value := range map[string]string{"oidcState": state, "oidcNonce": nonce, "oidcVerifier": verifier} {
+		err = session.PutString(w, key, value)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+
+	url := app.OIDC.OAuth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// OIDCCallback completes the authorization code flow. The code is exchanged for
+// an ID token, which is verified before the identity it describes is linked to
+// a local user and logged in. Users with two-factor authentication must still
+// enter their one-time code, as the provider's checks are out of our hands.
+func (app *App) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.NotFound(w, r)
+		return
+	}
+
+	// Users confirming who they are, rather than logging in, are sent back
+	// where they came from.
+	session := app.Sessions.Load(r)
+	reauth, err := session.PopString(w, "oidcReauth")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	// As are users linking the identity to their account from their settings.
+	linkUserID, err := session.PopInt(w, "oidcLink")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	identity, err := app.exchangeOIDC(w, r)
+	if err == nil && linkUserID != 0 {
+		app.linkOIDC(w, r, linkUserID, identity)
+		return
+	}
+
+	var user *models.User
+	if err == nil {
+		user, err = app.oidcUser(identity)
+	}
+	if err == models.ErrUnverifiedAccount && reauth == "" {
+		app.audit(r, 0, "login.failure", app.emailTarget(identity.Email), "oidc: unverified account")
+		app.countLogin(false)
+		app.redirectWithFlash(w, r, "/user/login", "An account with this email address already exists. Log in with its password, then link single sign-on from your settings.")
+		return
+	}
+	if err != nil && (reauth != "" || linkUserID != 0) {
+		app.Logger.WarnContext(r.Context(), "oidc reauthentication failed", "error", err)
+		app.redirectWithFlash(w, r, "/user/settings", "Single sign-on failed. Please try again.")
+		return
+	} else if err != nil {
+		// Don't give anything away to the user, but log what went wrong and
+		// send them back to the login page.
+		app.Logger.WarnContext(r.Context(), "oidc login failed", "error", err)
+		app.audit(r, 0, "login.failure", app.OIDC.Issuer, "oidc: "+err.Error())
+		app.countLogin(false)
+		app.redirectWithFlash(w, r, "/user/login", "Single sign-on failed. Please try again.")
+		return
+	}
+
+	if reauth != "" {
+		app.reauthOIDC(w, r, user, reauth)
+		return
+	}
+
+	if user.TOTPEnabled {
+		err = app.startTOTPLogin(w, r, user.ID)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/user/login/totp", http.StatusSeeOther)
+		return
+	}
+
+	err = app.logIn(w, r, user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "login.success", userTarget(user.ID), "oidc")
+	app.countLogin(true)
+
+	http.Redirect(w, r, "/snippet/new", http.StatusSeeOther)
+}
+
+// reauthOIDC completes a ReauthOIDC flow, recording that the user has just
+// confirmed who they are if the provider's identity is linked to the account
+// they're logged in to.
+func (app *App) reauthOIDC(w http.ResponseWriter, r *http.Request, user *models.User, next string) {
+	if userID := app.currentUserID(r); user.ID != userID {
+		app.Logger.WarnContext(r.Context(), "oidc reauthentication for another user", "user_id", userID, "oidc_user_id", user.ID)
+		app.redirectWithFlash(w, r, "/user/settings", "Single sign-on confirmed a different account from the one you're logged in to.")
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	err := session.PutTime(w, "reauthTime", time.Now())
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "user.reauth", userTarget(user.ID), "oidc")
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// reauthenticated reports whether the current user has confirmed who they are
+// through single sign-on within the last reauthWindow.
+func (app *App) reauthenticated(r *http.Request) (bool, error) {
+	session := app.Sessions.Load(r)
+	t, err := session.GetTime("reauthTime")
+	if err != nil {
+		return false, err
+	}
+	return !t.IsZero() && time.Since(t) < reauthWindow, nil
+}
+
+// linkOIDC completes a LinkOIDC flow, linking the provider's identity to the
+// account of the user who started it.
+func (app *App) linkOIDC(w http.ResponseWriter, r *http.Request, userID int, identity *oidcIdentity) {
+	if app.currentUserID(r) != userID {
+		app.redirectWithFlash(w, r, "/user/login", "Please log in again to link single sign-on.")
+		return
+	}
+
+	err := app.Database.AddIdentity(userID, app.OIDC.Issuer, identity.Subject)
+	if err == models.ErrIdentityLinked {
+		app.redirectWithFlash(w, r, "/user/settings", "That single sign-on account is already linked to another user.")
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, userID, "user.link_identity", userTarget(userID), app.OIDC.Issuer)
+
+	app.redirectWithFlash(w, r, "/user/settings", "You can now log in with single sign-on.")
+}
+
+// oidcIdentity is an identity at the provider, as described by a verified ID
+// token.
+type oidcIdentity struct {
+	Subject string
+	Email   string
+	Name    string
+}
+
+// oidcUser returns the local user to log in for an identity at the provider,
+// linking it to one if it's new.
+func (app *App) oidcUser(identity *oidcIdentity) (*models.User, error) {
+	userID, err := app.Database.LinkIdentity(app.OIDC.Issuer, identity.Subject, identity.Email, identity.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.Database.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Disabled {
+		return nil, fmt.Errorf("user %d is missing or disabled", userID)
+	}
+
+	return user, nil
+}
+
+// exchangeOIDC does the work of OIDCCallback, returning the identity the
+// provider vouches for.
+func (app *App) exchangeOIDC(w http.ResponseWriter, r *http.Request) (*oidcIdentity, error) {
+	session := app.Sessions.Load(r)
+	values := make(map[string]string)
+	for _, key := range []string{"oidcState", "oidcNonce", "oidcVerifier"} {
+		value, err := session.PopString(w, key)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			return nil, errors.New("no login in progress")
+		}
+		values[key] = value
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		return nil, errors.New("provider returned error: " + e)
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(values["oidcState"])) != 1 {
+		return nil, errors.New("state mismatch")
+	}
+
+	token, err := app.OIDC.OAuth2.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(values["oidcVerifier"]))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no id_token in token response")
+	}
+
+	idToken, err := app.OIDC.Verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(values["oidcNonce"])) != 1 {
+		return nil, errors.New("nonce mismatch")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// Identities are linked to users by email address, so we can only trust
+	// addresses which the provider has verified.
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("provider did not supply a verified email address")
+	}
+	if claims.Name == "" {
+		claims.Name = claims.Email
+	}
+
+	return &oidcIdentity{Subject: idToken.Subject, Email: claims.Email, Name: claims.Name}, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/vermeerp/snippetbox/pkg/forms"
+	"github.com/vermeerp/snippetbox/pkg/models"
+)
+
+// ReportSnippet renders the form for reporting a snippet.
+func (app *App) ReportSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.publicSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	app.RenderHTML(w, r, "report.page.html", &HTMLData{
+		Form:    &forms.ReportSnippet{},
+		Snippet: snippet,
+	})
+}
+
+// CreateReport records a report about a snippet for moderators to review.
+func (app *App) CreateReport(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	snippet := app.publicSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	form := &forms.ReportSnippet{
+		Reason:  r.PostForm.Get("reason"),
+		Details: r.PostForm.Get("details"),
+	}
+
+	if !form.Valid() {
+		app.RenderHTML(w, r, "report.page.html", &HTMLData{Form: form, Snippet: snippet})
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	userID, err := session.GetInt("currentUserID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.InsertReport(snippet.ID, userID, form.Reason, form.Details)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, userID, "snippet.report", snippetTarget(snippet.ID), form.Reason)
+
+	app.redirectWithFlash(w, r, fmt.Sprintf("/snippet/%d", snippet.ID), "Thanks for your report. A moderator will review it shortly.")
+}
+
+// AdminReports lists the open reports (the moderation queue), or the resolved
+// ones if the "resolved" query string parameter is set.
+func (app *App) AdminReports(w http.ResponseWriter, r *http.Request) {
+	resolved := r.URL.Query().Get("resolved") != ""
+	page := pageNumber(r)
+
+	reports, err := app.Database.ListReports(resolved, adminPageSize, (page-1)*adminPageSize)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "admin-reports.page.html", &HTMLData{
+		Flash:        flash,
+		Page:         page,
+		Reports:      reports,
+		ShowResolved: resolved,
+	})
+}
+
+// AdminShowReport displays a report, the snippet it is about and any other
+// reports about the same snippet, with a form for the moderator's decision.
+func (app *App) AdminShowReport(w http.ResponseWriter, r *http.Request) {
+	report := app.adminReport(w, r)
+	if report == nil {
+		return
+	}
+
+	app.renderAdminReport(w, r, report, &forms.ModerateReport{})
+}
+
+// AdminModerateReport carries out the moderator's decision on a report and
+// records it, along with their notes, on every open report about the snippet.
+func (app *App) AdminModerateReport(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	report := app.adminReport(w, r)
+	if report == nil {
+		return
+	}
+
+	form := &forms.ModerateReport{
+		Decision: r.PostForm.Get("decision"),
+		Notes:    r.PostForm.Get("notes"),
+	}
+
+	if !form.Valid() {
+		app.renderAdminReport(w, r, report, form)
+		return
+	}
+
+	switch form.Decision {
+	case models.DecisionHide:
+		err = app.Database.SetSnippetHidden(report.SnippetID, true)
+	case models.DecisionDelete:
+		err = app.Database.DeleteSnippet(report.SnippetID)
+	}
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	moderatorID, err := session.GetInt("currentUserID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.ResolveReports(report.SnippetID, moderatorID, form.Decision, form.Notes)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, moderatorID, "admin.report_resolve", snippetTarget(report.SnippetID), form.Decision)
+
+	app.redirectWithFlash(w, r, "/admin/reports", fmt.Sprintf("The reports about snippet #%d have been resolved.", report.SnippetID))
+}
+
+// publicSnippet loads the unexpired, visible snippet identified by the :id URL
+// parameter. If there is no such snippet, or something goes wrong, it sends an
+// error response and returns nil.
+func (app *App) publicSnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.NotFound(w, r)
+		return nil
+	}
+
+	snippet, err := app.Database.GetSnippet(id)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil
+	}
+	if snippet == nil {
+		app.NotFound(w, r)
+		return nil
+	}
+
+	return snippet
+}
+
+// adminReport loads the report identified by the :id URL parameter, in the
+// same way as adminUser.
+func (app *App) adminReport(w http.ResponseWriter, r *http.Request) *models.Report {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.NotFound(w, r)
+		return nil
+	}
+
+	report, err := app.Database.GetReport(id)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil
+	}
+	if report == nil {
+		app.NotFound(w, r)
+		return nil
+	}
+
+	return report
+}
+
+// renderAdminReport renders the moderation page for a report.
+func (app *App) renderAdminReport(w http.ResponseWriter, r *http.Request, report *models.Report, form *forms.ModerateReport) {
+	// The snippet is nil if it has already been deleted.
+	snippet, err := app.Database.GetAnySnippet(report.SnippetID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	reports, err := app.Database.SnippetReports(report.SnippetID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "admin-report.page.html", &HTMLData{
+		Form:    form,
+		Report:  report,
+		Reports: reports,
+		Snippet: snippet,
+	})
+}
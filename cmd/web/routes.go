@@ -4,23 +4,99 @@ import (
 	"net/http"
 
 	"github.com/bmizerany/pat" // New import
+	"github.com/vermeerp/snippetbox/pkg/models"
 )
 
 // Routes handles routing the request
 func (app *App) Routes() http.Handler {
-	mux := pat.New()
-	mux.Get("/", NoSurf(app.Home))
-	mux.Get("/snippet/new", app.RequireLogin(NoSurf(app.NewSnippet)))
-	mux.Post("/snippet/new", app.RequireLogin(NoSurf(app.CreateSnippet)))
-	mux.Get("/snippet/:id", NoSurf(app.ShowSnippet))
-	mux.Get("/user/signup", NoSurf(app.SignupUser))
-	mux.Post("/user/signup", NoSurf(app.CreateUser))
-	mux.Get("/user/login", NoSurf(app.LoginUser))
-	mux.Post("/user/login", NoSurf(app.VerifyUser))
-	mux.Post("/user/logout", app.RequireLogin(NoSurf(app.LogoutUser)))
-
-	fileServer := http.FileServer(http.Dir(app.StaticDir))
-	mux.Get("/static/", http.StripPrefix("/static", fileServer))
-
-	return LogRequest(SecureHeaders(mux))
+	mux := router{pat.New()}
+	mux.NotFound = http.HandlerFunc(app.NotFound)
+	mux.Get("/", app.NoSurf(app.Home))
+	mux.Get("/snippet/new", app.RequireLogin(app.RequireVerified(app.NoSurf(app.NewSnippet))))
+	mux.Post("/snippet/new", app.RequireLogin(app.RequireVerified(app.NoSurf(app.CreateSnippet))))
+	mux.Get("/snippet/:id", app.NoSurf(app.ShowSnippet))
+	mux.Get("/snippet/:id/report", app.RequireLogin(app.NoSurf(app.ReportSnippet)))
+	mux.Post("/snippet/:id/report", app.RequireLogin(app.NoSurf(app.CreateReport)))
+	mux.Get("/user/signup", app.NoSurf(app.SignupUser))
+	mux.Post("/user/signup", app.NoSurf(app.CreateUser))
+	mux.Get("/user/login", app.NoSurf(app.LoginUser))
+	mux.Post("/user/login", app.NoSurf(app.VerifyUser))
+	mux.Get("/user/login/oidc", app.NoSurf(app.LoginOIDC))
+	mux.Get("/user/login/oidc/callback", app.NoSurf(app.OIDCCallback))
+	mux.Get("/user/reauth/oidc", app.RequireLogin(app.NoSurf(app.ReauthOIDC)))
+	mux.Get("/user/login/totp", app.NoSurf(app.LoginTOTP))
+	mux.Post("/user/login/totp", app.NoSurf(app.VerifyLoginTOTP))
+	mux.Post("/user/logout", app.RequireLogin(app.NoSurf(app.LogoutUser)))
+	mux.Get("/user/verify", app.RequireLogin(app.NoSurf(app.ShowVerification)))
+	mux.Post("/user/verify", app.RequireLogin(app.NoSurf(app.ResendVerification)))
+	mux.Get("/user/verify/:token", app.NoSurf(app.VerifyEmail))
+	mux.Get("/user/settings", app.RequireLogin(app.NoSurf(app.ShowSettings)))
+	mux.Get("/user/settings/name", app.RequireLogin(app.NoSurf(app.EditName)))
+	mux.Post("/user/settings/name", app.RequireLogin(app.NoSurf(app.UpdateName)))
+	mux.Get("/user/settings/email", app.RequireLogin(app.NoSurf(app.EditEmail)))
+	mux.Post("/user/settings/email", app.RequireLogin(app.NoSurf(app.UpdateEmail)))
+	mux.Get("/user/settings/email/confirm/:token", app.NoSurf(app.ConfirmEmailChange))
+	mux.Get("/user/settings/password", app.RequireLogin(app.NoSurf(app.EditPassword)))
+	mux.Post("/user/settings/password", app.RequireLogin(app.NoSurf(app.UpdatePassword)))
+	mux.Get("/user/settings/sso", app.RequireLogin(app.NoSurf(app.ConfirmLinkOIDC)))
+	mux.Post("/user/settings/sso", app.RequireLogin(app.NoSurf(app.LinkOIDC)))
+	mux.Get("/user/settings/export", app.RequireLogin(app.NoSurf(app.ExportData)))
+	mux.Get("/user/settings/delete", app.RequireLogin(app.NoSurf(app.ConfirmDeletion)))
+	mux.Post("/user/settings/delete", app.RequireLogin(app.NoSurf(app.DeleteAccount)))
+	mux.Post("/user/settings/delete/cancel", app.RequireLogin(app.NoSurf(app.CancelDeletion)))
+	mux.Get("/user/sessions", app.RequireLogin(app.NoSurf(app.ShowSessions)))
+	mux.Post("/user/sessions/revoke", app.RequireLogin(app.NoSurf(app.RevokeSession)))
+	mux.Post("/user/sessions/revoke-all", app.RequireLogin(app.NoSurf(app.RevokeAllSessions)))
+	mux.Get("/user/totp", app.RequireLogin(app.NoSurf(app.ShowTOTP)))
+	mux.Get("/user/totp/setup", app.RequireLogin(app.NoSurf(app.SetupTOTP)))
+	mux.Post("/user/totp/setup", app.RequireLogin(app.NoSurf(app.EnableTOTP)))
+	mux.Post("/user/totp/disable", app.RequireLogin(app.NoSurf(app.DisableTOTP)))
+	mux.Post("/user/totp/recovery-codes", app.RequireLogin(app.NoSurf(app.RegenerateRecoveryCodes)))
+
+	// The admin console. Moderators can manage snippets, while only admins can
+	// manage users. It may also need a client certificate.
+	staff := func(role string, next http.HandlerFunc) http.Handler {
+		h := app.NoSurf(next)
+		if app.ClientCerts != nil && app.ClientCerts.Routes[ClientCertAdmin] {
+			h = app.RequireClientCert(role, h)
+		}
+		return app.RequireLogin(app.RequireRole(role, h))
+	}
+	mod := func(next http.HandlerFunc) http.Handler {
+		return staff(models.RoleModerator, next)
+	}
+	admin := func(next http.HandlerFunc) http.Handler {
+		return staff(models.RoleAdmin, next)
+	}
+	mux.Get("/admin", mod(app.AdminHome))
+	mux.Get("/admin/users", admin(app.AdminUsers))
+	mux.Get("/admin/users/:id", admin(app.AdminShowUser))
+	mux.Post("/admin/users/:id/disable", admin(app.AdminDisableUser))
+	mux.Post("/admin/users/:id/enable", admin(app.AdminEnableUser))
+	mux.Post("/admin/users/:id/reset", admin(app.AdminResetCredentials))
+	mux.Post("/admin/users/:id/unlock", admin(app.AdminUnlockUser))
+	mux.Post("/admin/users/:id/role", admin(app.AdminSetRole))
+	mux.Get("/admin/audit", admin(app.AdminAudit))
+	mux.Get("/admin/audit/export", admin(app.AdminExportAudit))
+	mux.Get("/admin/snippets", mod(app.AdminSnippets))
+	mux.Get("/admin/snippets/:id", mod(app.AdminShowSnippet))
+	mux.Post("/admin/snippets/:id/hide", mod(app.AdminHideSnippet))
+	mux.Post("/admin/snippets/:id/unhide", mod(app.AdminUnhideSnippet))
+	mux.Post("/admin/snippets/:id/delete", mod(app.AdminDeleteSnippet))
+	mux.Get("/admin/reports", mod(app.AdminReports))
+	mux.Get("/admin/reports/:id", mod(app.AdminShowReport))
+	mux.Post("/admin/reports/:id", mod(app.AdminModerateReport))
+
+	mux.Get("/static/", app.Static)
+
+	site := RequestID(app.LogRequest(app.Instrument(app.RecoverPanic(app.SecureHeaders(app.Authenticate(mux))))))
+
+	// Probes are answered before any of the middleware, so that they stay out
+	// of the access log and don't need a session or CSRF token.
+	probes := http.NewServeMux()
+	probes.Handle("/healthz", app.RecoverPanic(http.HandlerFunc(app.Healthz)))
+	probes.Handle("/readyz", app.RecoverPanic(http.HandlerFunc(app.Readyz)))
+	probes.Handle("/", site)
+
+	return probes
 }
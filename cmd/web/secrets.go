@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/vermeerp/snippetbox/pkg/forms"
+	"github.com/vermeerp/snippetbox/pkg/secrets"
+)
+
+// The policies for credentials found in new snippets.
+const (
+	SecretPolicyBlock   = "block"   // refuse to save the snippet
+	SecretPolicyRedact  = "redact"  // replace the credentials with a placeholder
+	SecretPolicyConfirm = "confirm" // save only once the author has confirmed
+)
+
+// ScanSnippet looks for credentials in the content of a new snippet and applies
+// the configured policy to them. It returns what was found, and whether the
+// snippet can be saved. Under the redact policy the form's content is changed
+// in place. Every path which creates snippets must call this.
+func (app *App) ScanSnippet(form *forms.NewSnippet) ([]secrets.Finding, bool) {
+	findings := secrets.Scan(form.Content)
+	if len(findings) == 0 {
+		return nil, true
+	}
+
+	switch app.SecretPolicy {
+	case SecretPolicyRedact:
+		form.Content = secrets.Redact(form.Content, findings)
+		return findings, true
+	case SecretPolicyBlock:
+		form.Failures["Content"] = "Content appears to contain secrets. Please remove them."
+		return findings, false
+	default:
+		if !form.ConfirmSecrets {
+			form.Failures["Content"] = "Content appears to contain secrets. Please remove them, or confirm that they are safe to publish."
+		}
+		return findings, form.ConfirmSecrets
+	}
+}
@@ -1,19 +1,37 @@
 package main
 
 import (
+	"context"
 	"crypto/tls" // New import
-	"log"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/vermeerp/snippetbox/pkg/models"
 )
 
-// RunServer runs the server
-func (app *App) RunServer() {
+// RunServer runs the server until ctx is cancelled, then shuts it down
+// gracefully: /readyz starts failing, and after ShutdownDelay the listeners
+// are closed and in-flight requests are given up to DrainTimeout to finish.
+func (app *App) RunServer(ctx context.Context) error {
 	// Declare a tls.Config variable to hold the non-default TLS settings we want the
 	// server to use.
 	tlsConfig := &tls.Config{
 		PreferServerCipherSuites: true,
 		CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
+		GetCertificate:           app.Certs.GetCertificate,
+	}
+
+	// Ask for client certificates if they're used for any routes, but don't
+	// insist, as the rest of the site is public.
+	if app.ClientCerts != nil {
+		tlsConfig.ClientCAs = app.ClientCerts.CAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
 	}
 
 	// Initialize a new http.Server struct. We set the Addr and Handler so that
@@ -22,15 +40,158 @@ func (app *App) RunServer() {
 	srv := &http.Server{
 		Addr:         app.Addr,
 		Handler:      app.Routes(),
+		ErrorLog:     slog.NewLogLogger(app.Logger.Handler(), slog.LevelError),
 		TLSConfig:    tlsConfig,
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
+	servers := []*http.Server{srv}
+	errs := make(chan error, 3)
+
+	// Call the http.Server's ListenAndServeTLS() method to start the server.
+	// The certificate comes from app.Certs, so no files are passed in.
+	app.Logger.Info("starting server", "addr", app.Addr)
+	go func() {
+		errs <- srv.ListenAndServeTLS("", "")
+	}()
+
+	// Serve metrics on their own listener, so that they can be kept off the
+	// public network.
+	if app.MetricsAddr != "" {
+		metrics := app.metricsServer()
+		servers = append(servers, metrics)
+
+		app.Logger.Info("starting metrics server", "addr", app.MetricsAddr)
+		go func() {
+			if metrics.TLSConfig != nil {
+				errs <- metrics.ListenAndServeTLS("", "")
+			} else {
+				errs <- metrics.ListenAndServe()
+			}
+		}()
+	}
+
+	// Send visitors to plain HTTP over to HTTPS.
+	if app.HTTPAddr != "" {
+		redirect := app.redirectServer()
+		servers = append(servers, redirect)
+
+		app.Logger.Info("starting HTTP redirect server", "addr", app.HTTPAddr)
+		go func() {
+			errs <- redirect.ListenAndServe()
+		}()
+	}
+
+	// Run until we're told to stop, or a listener fails.
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	// Tell the load balancer to stop sending us traffic, and give it time to
+	// notice before we stop accepting connections.
+	app.shuttingDown.Store(true)
+	if err == nil {
+		app.Logger.Info("shutting down", "delay", app.ShutdownDelay, "drain_timeout", app.DrainTimeout)
+		time.Sleep(app.ShutdownDelay)
+	}
+
+	// Drain the servers at the same time, so that a slow one doesn't use up
+	// the others' time.
+	drainCtx, cancel := context.WithTimeout(context.Background(), app.DrainTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *http.Server) {
+			defer wg.Done()
+			shutdownErr := s.Shutdown(drainCtx)
+			if shutdownErr != nil {
+				app.Logger.Warn("connections still open at the end of the drain timeout", "addr", s.Addr, "error", shutdownErr)
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// metricsServer returns a server for /metrics on MetricsAddr. This is plain
+// HTTP, unless metrics need a client certificate, in which case it's HTTPS and
+// only admins' certificates are accepted.
+func (app *App) metricsServer() *http.Server {
+	var h http.Handler = app.Metrics.Handler()
+	var tlsConfig *tls.Config
+	if app.ClientCerts != nil && app.ClientCerts.Routes[ClientCertMetrics] {
+		h = app.RequireClientCert(models.RoleAdmin, h)
+		tlsConfig = &tls.Config{
+			GetCertificate: app.Certs.GetCertificate,
+			ClientCAs:      app.ClientCerts.CAs,
+			ClientAuth:     tls.RequireAndVerifyClientCert,
+		}
+	}
 
-	// Call the http.Server's ListenAndServeTLS() method to start the server,
-	// passing in the paths to the TLS certificate and corresponding private key.
-	log.Printf("Starting server on %s", app.Addr)
-	err := srv.ListenAndServeTLS(app.TLSCert, app.TLSKey)
-	log.Fatal(err)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+
+	return &http.Server{
+		Addr:         app.MetricsAddr,
+		Handler:      mux,
+		ErrorLog:     slog.NewLogLogger(app.Logger.Handler(), slog.LevelError),
+		TLSConfig:    tlsConfig,
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+// redirectServer returns a server for plain HTTP on HTTPAddr, which redirects
+// everything to the same path and query over HTTPS. It also answers the health
+// checks, for probes which can't speak TLS.
+func (app *App) redirectServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", app.RecoverPanic(http.HandlerFunc(app.Healthz)))
+	mux.Handle("/readyz", app.RecoverPanic(http.HandlerFunc(app.Readyz)))
+	mux.HandleFunc("/", app.RedirectToHTTPS)
+
+	return &http.Server{
+		Addr:         app.HTTPAddr,
+		Handler:      mux,
+		ErrorLog:     slog.NewLogLogger(app.Logger.Handler(), slog.LevelError),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+// RedirectToHTTPS redirects a plain HTTP request to the HTTPS listener on the
+// same host.
+func (app *App) RedirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	// Leave the port out if it's the default one.
+	if _, port, err := net.SplitHostPort(app.Addr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	u := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+
+	// 301 lets clients switch POSTs to GETs; 308 doesn't.
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, u.String(), status)
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sessionLifetime is how long a session lasts before the user has to log in
+// again.
+const sessionLifetime = 12 * time.Hour
+
+// ShowSessions lists the sessions in which the current user is logged in.
+func (app *App) ShowSessions(w http.ResponseWriter, r *http.Request) {
+	session := app.Sessions.Load(r)
+	userID, err := session.GetInt("currentUserID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	token, err := session.GetString("sessionID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	sessions, err := app.Database.UserSessions(userID, token)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "sessions.page.html", &HTMLData{
+		Flash:        flash,
+		UserSessions: sessions,
+	})
+}
+
+// RevokeSession logs the current user out of one of their other sessions.
+func (app *App) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	userID, err := session.GetInt("currentUserID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.DeleteSession(userID, r.PostForm.Get("id"))
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, userID, "session.revoke", userTarget(userID), "")
+
+	err = session.PutString(w, "flash", "The session has been logged out.")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+// RevokeAllSessions logs the current user out everywhere, including the
+// current session.
+func (app *App) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	session := app.Sessions.Load(r)
+	userID, err := session.GetInt("currentUserID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.DeleteUserSessions(userID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, userID, "session.revoke_all", userTarget(userID), "")
+
+	err = session.Destroy(w)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// device returns a short description of the browser and operating system in a
+// User-Agent string, such as "Firefox on Linux". It only knows about the most
+// common ones; anything else is described as an unknown device.
+func device(userAgent string) string {
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	os := "unknown device"
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			os = o.name
+			break
+		}
+	}
+
+	return browser + " on " + os
+}
+
+// PurgeSessions forgets the logged in sessions which have expired, checking
+// every interval until ctx is cancelled. Their session data is cleaned up by
+// the session store, but without this they would still be listed as active.
+func (app *App) PurgeSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := app.Database.DeleteExpiredSessions(sessionLifetime)
+		if err != nil {
+			app.Logger.Error("purging expired sessions", "error", err)
+			continue
+		}
+		if n > 0 {
+			app.Logger.Debug("purged expired sessions", "count", n)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/vermeerp/snippetbox/pkg/forms"
+	"github.com/vermeerp/snippetbox/pkg/models"
+)
+
+// ShowSettings displays the account settings of the current user.
+func (app *App) ShowSettings(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "settings.page.html", &HTMLData{
+		Flash: flash,
+		User:  user,
+	})
+}
+
+// EditName renders the form for changing the display name.
+func (app *App) EditName(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "settings-name.page.html", &HTMLData{
+		Form: &forms.ChangeName{Name: user.Name},
+	})
+}
+
+// UpdateName changes the display name of the current user.
+func (app *App) UpdateName(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	form := &forms.ChangeName{
+		Name: r.PostForm.Get("name"),
+	}
+
+	if !form.Valid() {
+		app.RenderHTML(w, r, "settings-name.page.html", &HTMLData{Form: form})
+		return
+	}
+
+	err = app.Database.UpdateUserName(user.ID, form.Name)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "user.change_name", userTarget(user.ID), "")
+
+	app.redirectWithFlash(w, r, "/user/settings", "Your name has been changed.")
+}
+
+// EditEmail renders the form for changing the email address.
+func (app *App) EditEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	reauthenticated, err := app.reauthenticated(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "settings-email.page.html", &HTMLData{
+		Form: &forms.ChangeEmail{Email: user.Email, Reauthenticated: reauthenticated},
+	})
+}
+
+// UpdateEmail starts changing the email address of the current user, after
+// checking their password (or that they've just reauthenticated). The new
+// address is only put in place by ConfirmEmailChange, once the user has
+// followed the link sent to it.
+func (app *App) UpdateEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	reauthenticated, err := app.reauthenticated(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	form := &forms.ChangeEmail{
+		Email:           r.PostForm.Get("email"),
+		Password:        r.PostForm.Get("password"),
+		Reauthenticated: reauthenticated,
+	}
+
+	if !form.Valid() {
+		app.RenderHTML(w, r, "settings-email.page.html", &HTMLData{Form: form})
+		return
+	}
+
+	if !form.Reauthenticated {
+		err = app.Database.VerifyPassword(user.ID, form.Password)
+		if err == models.ErrInvalidCredentials {
+			form.Failures["Password"] = "Password is incorrect"
+			app.RenderHTML(w, r, "settings-email.page.html", &HTMLData{Form: form})
+			return
+		} else if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+
+	if form.Email == user.Email {
+		http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+		return
+	}
+
+	token, err := app.Database.RequestEmailChange(user.ID, form.Email)
+	if err == models.ErrDuplicateEmail {
+		form.Failures["Email"] = "Address is already in use"
+		app.RenderHTML(w, r, "settings-email.page.html", &HTMLData{Form: form})
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "user.request_email_change", userTarget(user.ID), app.emailTarget(form.Email))
+
+	link := fmt.Sprintf("%s/user/settings/email/confirm/%s", app.BaseURL, token)
+	body := fmt.Sprintf("Please confirm the new email address of your Snippetbox account by visiting the link below within 24 hours.\n\n%s\n", link)
+	err = app.Mailer.Send(form.Email, "Confirm your new Snippetbox email address", body)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "sending email change confirmation", "user_id", user.ID, "error", err)
+	}
+
+	app.redirectWithFlash(w, r, "/user/settings", "Please check the inbox of your new address and follow the link there to finish changing it.")
+}
+
+// ConfirmEmailChange puts a pending email address in place when the user
+// follows the link sent to it, and lets the old address know.
+func (app *App) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	userID, oldEmail, newEmail, err := app.Database.ConfirmEmailChange(r.URL.Query().Get(":token"))
+	if err == models.ErrInvalidToken {
+		app.RenderHTML(w, r, "verify.page.html", &HTMLData{
+			Flash: "This link is invalid or has expired.",
+		})
+		return
+	} else if err == models.ErrDuplicateEmail {
+		app.RenderHTML(w, r, "verify.page.html", &HTMLData{
+			Flash: "This address has been taken by another account in the meantime.",
+		})
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, userID, "user.change_email", userTarget(userID), app.emailTarget(oldEmail)+" -> "+app.emailTarget(newEmail))
+
+	// Let the old address know about the change, in case it wasn't made by
+	// its owner.
+	err = app.Mailer.Send(oldEmail, "Your Snippetbox email address has changed",
+		"The email address of your Snippetbox account has been changed to "+newEmail+".\n")
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "sending email change notice", "user_id", userID, "error", err)
+	}
+
+	app.redirectWithFlash(w, r, "/user/settings", "Your email address has been changed.")
+}
+
+// EditPassword renders the form for changing the password.
+func (app *App) EditPassword(w http.ResponseWriter, r *http.Request) {
+	reauthenticated, err := app.reauthenticated(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "settings-password.page.html", &HTMLData{
+		Form: &forms.ChangePassword{Reauthenticated: reauthenticated},
+	})
+}
+
+// UpdatePassword changes the password of the current user, after checking their
+// current one (or that they've just reauthenticated, so that users who log in
+// through single sign-on can set one). All of their other sessions are logged
+// out.
+func (app *App) UpdatePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	reauthenticated, err := app.reauthenticated(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	form := &forms.ChangePassword{
+		CurrentPassword: r.PostForm.Get("current_password"),
+		NewPassword:     r.PostForm.Get("new_password"),
+		ConfirmPassword: r.PostForm.Get("confirm_password"),
+		Reauthenticated: reauthenticated,
+	}
+
+	if !form.Valid() {
+		app.RenderHTML(w, r, "settings-password.page.html", &HTMLData{Form: form})
+		return
+	}
+
+	if !form.Reauthenticated {
+		err = app.Database.VerifyPassword(user.ID, form.CurrentPassword)
+		if err == models.ErrInvalidCredentials {
+			form.Failures["CurrentPassword"] = "Password is incorrect"
+			app.RenderHTML(w, r, "settings-password.page.html", &HTMLData{Form: form})
+			return
+		} else if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+
+	err = app.Database.UpdatePassword(user.ID, form.NewPassword)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "user.change_password", userTarget(user.ID), "")
+
+	session := app.Sessions.Load(r)
+	token, err := session.GetString("sessionID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.Database.DeleteOtherSessions(user.ID, token)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.redirectWithFlash(w, r, "/user/settings", "Your password has been changed and your other sessions have been logged out.")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Static serves the static assets. Their URLs include a hash of their
+// contents, so that they can be cached indefinitely: a changed file gets a
+// new URL.
+type Static struct {
+	FS fs.FS
+
+	// NoCache turns off caching of the hashes, for development, when the
+	// files may change under us.
+	NoCache bool
+
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+// URL returns the URL of the named asset, such as "css/main.css", with the
+// hash of its contents in the query string. Assets which can't be read get a
+// plain URL, so a missing file shows up as a 404 rather than a broken page.
+func (s *Static) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	url := "/static/" + name
+
+	hash, err := s.hash(name)
+	if err != nil {
+		return url
+	}
+	return url + "?v=" + hash
+}
+
+func (s *Static) hash(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if hash, ok := s.hashes[name]; ok && !s.NoCache {
+		return hash, nil
+	}
+
+	b, err := fs.ReadFile(s.FS, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:6])
+
+	if s.hashes == nil {
+		s.hashes = map[string]string{}
+	}
+	s.hashes[name] = hash
+	return hash, nil
+}
+
+// ServeHTTP serves the asset at the path under /static/. Requests carrying
+// the current hash may be cached for a year; anything else must be checked
+// with the server each time.
+func (s *Static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+
+	cacheControl := "no-cache"
+	if v := r.URL.Query().Get("v"); v != "" {
+		if hash, err := s.hash(name); err == nil && v == hash {
+			cacheControl = "public, max-age=31536000, immutable"
+		}
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+
+	http.StripPrefix("/static", http.FileServer(http.FS(s.FS))).ServeHTTP(w, r)
+}
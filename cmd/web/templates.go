@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+)
+
+// Templates holds every page template, parsed once up front. The files in the
+// template directory are:
+//
+//   - base.html, the default layout, defining "base"
+//   - *.layout.html, other layouts
+//   - *.partial.html, snippets of markup shared between pages
+//   - *.page.html, the pages, each defining "page-title" and "page-body"
+//
+// Every page is parsed along with all the layouts and partials. Pages use the
+// "base" layout unless they define "layout" to use another, for example
+//
+//	{{define "layout"}}{{template "minimal" .}}{{end}}
+type Templates struct {
+	FS     fs.FS
+	Funcs  template.FuncMap
+	Logger *slog.Logger
+
+	mu      sync.RWMutex
+	pages   map[string]*template.Template
+	modTime time.Time // the newest file's modification time
+	err     error     // why the last reload failed, if it did
+}
+
+// NewTemplates parses the templates in fsys, failing on any errors.
+func NewTemplates(fsys fs.FS, funcs template.FuncMap, logger *slog.Logger) (*Templates, error) {
+	t := &Templates{FS: fsys, Funcs: funcs, Logger: logger}
+	err := t.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Get returns the template for a page, or nil if there's no such page.
+func (t *Templates) Get(page string) *template.Template {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.pages[page]
+}
+
+// Err returns the reason the last reload failed, or nil if it didn't.
+func (t *Templates) Err() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.err
+}
+
+// Reload parses all the templates again. If any fail to parse, the ones
+// already loaded are kept.
+func (t *Templates) Reload() error {
+	pages, modTime, err := t.parse()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.err = err
+	if err != nil {
+		return err
+	}
+	t.pages = pages
+	t.modTime = modTime
+	return nil
+}
+
+func (t *Templates) parse() (map[string]*template.Template, time.Time, error) {
+	modTime, err := t.newestModTime()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	pages, err := fs.Glob(t.FS, "*.page.html")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(pages) == 0 {
+		return nil, time.Time{}, errors.New("no page templates found")
+	}
+
+	shared := []string{"base.html"}
+	for _, pattern := range []string{"*.layout.html", "*.partial.html"} {
+		files, err := fs.Glob(t.FS, pattern)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		shared = append(shared, files...)
+	}
+
+	cache := map[string]*template.Template{}
+	for _, page := range pages {
+		// Parse the page last, so that its "layout" replaces the default.
+		ts, err := template.New("layout").Funcs(t.Funcs).Parse(`{{template "base" .}}`)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		ts, err = ts.ParseFS(t.FS, append(shared, page)...)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		cache[path.Base(page)] = ts
+	}
+
+	return cache, modTime, nil
+}
+
+// Watch reparses the templates whenever any of them change, checking every
+// interval until ctx is cancelled. It's meant for development, so that
+// changes show up without a restart.
+func (t *Templates) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := t.newestModTime()
+		if err != nil {
+			t.Logger.Error("checking templates", "error", err)
+			continue
+		}
+
+		t.mu.RLock()
+		changed := !modTime.Equal(t.modTime)
+		t.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		err = t.Reload()
+		if err != nil {
+			t.Logger.Error("reloading templates, keeping the old ones", "error", err)
+			// Don't try again until something else changes.
+			t.mu.Lock()
+			t.modTime = modTime
+			t.mu.Unlock()
+			continue
+		}
+		t.Logger.Info("reloaded templates")
+	}
+}
+
+// newestModTime returns the modification time of the most recently changed
+// template.
+func (t *Templates) newestModTime() (time.Time, error) {
+	files, err := fs.Glob(t.FS, "*.html")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var newest time.Time
+	for _, file := range files {
+		info, err := fs.Stat(t.FS, file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
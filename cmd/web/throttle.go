@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Login attempts are throttled per account and per client IP address. Once the
+// number of failed attempts reaches the threshold, further logins are locked
+// out for lockoutBase, doubling with every subsequent failure up to
+// lockoutMax. The IP threshold is higher, as several people may share an
+// address.
+const (
+	accountFailureThreshold = 5
+	ipFailureThreshold      = 20
+	lockoutBase             = time.Minute
+	lockoutMax              = time.Hour
+)
+
+// lockedOutMessage is shown on the login page when an account or IP address is
+// locked out. It deliberately doesn't say which, or whether the account exists.
+const lockedOutMessage = "Too many failed login attempts. Please try again later."
+
+// accountKey returns the name under which failed logins for an email address
+// are counted.
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipKey returns the name under which failed logins from the client are
+// counted.
+func ipKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// loginLocked reports whether logins for the email address, or from the client
+// making the request, are currently locked out.
+func (app *App) loginLocked(r *http.Request, email string) (bool, error) {
+	until, err := app.Database.LoginLockedUntil(accountKey(email), ipKey(r))
+	if err != nil {
+		return false, err
+	}
+
+	return !until.IsZero(), nil
+}
+
+// loginFailed records a failed login attempt for the email address and the
+// client, locking them out if they've reached their threshold.
+func (app *App) loginFailed(r *http.Request, email string) error {
+	for _, l := range []struct {
+		key       string
+		target    string // how the key is identified in the audit log
+		threshold int
+	}{
+		{accountKey(email), app.emailTarget(email), accountFailureThreshold},
+		{ipKey(r), ipKey(r), ipFailureThreshold},
+	} {
+		failures, err := app.Database.RecordLoginFailure(l.key)
+		if err != nil {
+			return err
+		}
+		if failures < l.threshold {
+			continue
+		}
+
+		until := time.Now().Add(lockoutDuration(failures - l.threshold))
+		err = app.Database.LockLogin(l.key, until)
+		if err != nil {
+			return err
+		}
+		app.Logger.WarnContext(r.Context(), "login locked out", "key", l.target, "until", until.UTC(), "failures", failures)
+		app.audit(r, 0, "login.lockout", l.target, fmt.Sprintf("locked until %s after %d failed attempts", until.UTC().Format(time.RFC3339), failures))
+	}
+
+	return nil
+}
+
+// loginSucceeded clears the failed login attempts for the email address. The
+// count for the client's IP address is left alone, so that an attacker can't
+// reset it by logging in to their own account.
+func (app *App) loginSucceeded(email string) error {
+	return app.Database.ClearLoginFailures(accountKey(email))
+}
+
+// lockoutDuration returns how long to lock out logins for, given the number of
+// failures beyond the threshold.
+func lockoutDuration(excess int) time.Duration {
+	d := lockoutBase
+	for i := 0; i < excess && d < lockoutMax; i++ {
+		d *= 2
+	}
+	if d > lockoutMax {
+		d = lockoutMax
+	}
+	return d
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		excess int
+		want   time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{5, 32 * time.Minute},
+		{6, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := lockoutDuration(tt.excess); got != tt.want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", tt.excess, got, tt.want)
+		}
+	}
+}
+
+func TestAccountKey(t *testing.T) {
+	// Variations of the same address must share a failure count.
+	want := accountKey("alice@example.com")
+	for _, email := range []string{"Alice@Example.com", "  alice@example.com ", "ALICE@EXAMPLE.COM"} {
+		if got := accountKey(email); got != want {
+			t.Errorf("accountKey(%q) = %q, want %q", email, got, want)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/vermeerp/snippetbox/pkg/forms"
+	"github.com/vermeerp/snippetbox/pkg/models"
+	"github.com/vermeerp/snippetbox/pkg/totp"
+)
+
+// pendingLoginTimeout is how long a user has to enter their one-time code after
+// entering a correct password.
+const pendingLoginTimeout = 5 * time.Minute
+
+// ShowTOTP displays the two-factor authentication settings of the current user.
+func (app *App) ShowTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	reauthenticated, err := app.reauthenticated(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "totp.page.html", &HTMLData{
+		Flash: flash,
+		Form:  &forms.ConfirmPassword{Reauthenticated: reauthenticated},
+		User:  user,
+	})
+}
+
+// SetupTOTP generates a new secret for the current user and displays it as a
+// QR code. The secret is kept in the session until the user has proved that
+// their authenticator app is set up correctly by entering a valid code.
+func (app *App) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if user.TOTPEnabled {
+		http.Redirect(w, r, "/user/totp", http.StatusSeeOther)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	err = session.PutString(w, "totpSecret", secret)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.renderTOTPSetup(w, r, user, secret, &forms.TOTPCode{})
+}
+
+// EnableTOTP checks the code entered during setup and, if it is valid, turns on
+// two-factor authentication and displays the recovery codes.
+func (app *App) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	secret, err := session.GetString("totpSecret")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if secret == "" {
+		http.Redirect(w, r, "/user/totp/setup", http.StatusSeeOther)
+		return
+	}
+
+	form := &forms.TOTPCode{
+		Code: r.PostForm.Get("code"),
+	}
+
+	if !form.Valid() {
+		app.renderTOTPSetup(w, r, user, secret, form)
+		return
+	}
+
+	if _, ok := totp.Validate(secret, form.Code, time.Now(), 0); !ok {
+		form.Failures["Code"] = "Code is incorrect"
+		app.renderTOTPSetup(w, r, user, secret, form)
+		return
+	}
+
+	codes, err := app.Database.EnableTOTP(user.ID, secret)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "user.totp_enable", userTarget(user.ID), "")
+
+	err = session.Remove(w, "totpSecret")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "recovery-codes.page.html", &HTMLData{
+		Flash:         "Two-factor authentication is now enabled.",
+		RecoveryCodes: codes,
+	})
+}
+
+// DisableTOTP turns off two-factor authentication for the current user, after
+// checking their password (or that they've just reauthenticated).
+func (app *App) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	reauthenticated, err := app.reauthenticated(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	form := &forms.ConfirmPassword{
+		Password:        r.PostForm.Get("password"),
+		Reauthenticated: reauthenticated,
+	}
+
+	if !form.Valid() {
+		app.RenderHTML(w, r, "totp.page.html", &HTMLData{Form: form, User: user})
+		return
+	}
+
+	if !form.Reauthenticated {
+		err = app.Database.VerifyPassword(user.ID, form.Password)
+		if err == models.ErrInvalidCredentials {
+			form.Failures["Password"] = "Password is incorrect"
+			app.RenderHTML(w, r, "totp.page.html", &HTMLData{Form: form, User: user})
+			return
+		} else if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+
+	err = app.Database.DisableTOTP(user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "user.totp_disable", userTarget(user.ID), "")
+
+	session := app.Sessions.Load(r)
+	err = session.PutString(w, "flash", "Two-factor authentication has been disabled.")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/totp", http.StatusSeeOther)
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes and
+// displays the new ones.
+func (app *App) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Redirect(w, r, "/user/totp", http.StatusSeeOther)
+		return
+	}
+
+	codes, err := app.Database.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, user.ID, "user.recovery_codes", userTarget(user.ID), "")
+
+	app.RenderHTML(w, r, "recovery-codes.page.html", &HTMLData{
+		Flash:         "Your old recovery codes no longer work.",
+		RecoveryCodes: codes,
+	})
+}
+
+// LoginTOTP renders the second step of the login form, where users with
+// two-factor authentication enter their one-time code.
+func (app *App) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.pendingUserID(w, r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if userID == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.RenderHTML(w, r, "login-totp.page.html", &HTMLData{
+		Form: &forms.TOTPCode{},
+	})
+}
+
+// VerifyLoginTOTP checks the one-time code (or a recovery code) and completes
+// the login.
+func (app *App) VerifyLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := app.pendingUserID(w, r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if userID == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	form := &forms.TOTPCode{
+		Code: r.PostForm.Get("code"),
+	}
+
+	if !form.Valid() {
+		app.RenderHTML(w, r, "login-totp.page.html", &HTMLData{Form: form})
+		return
+	}
+
+	// One-time codes are throttled in the same way as passwords, as there are
+	// only a million of them.
+	user, err := app.Database.GetUser(userID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	// The account may have been purged or disabled since the password was
+	// checked, in which case start again.
+	if user == nil || user.Disabled {
+		session := app.Sessions.Load(r)
+		err = session.Remove(w, "pendingUserID")
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	locked, err := app.loginLocked(r, user.Email)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if locked {
+		app.audit(r, 0, "login.failure", userTarget(userID), "locked out")
+		app.countLogin(false)
+		form.Failures["Code"] = lockedOutMessage
+		app.RenderHTML(w, r, "login-totp.page.html", &HTMLData{Form: form})
+		return
+	}
+
+	// Codes from the authenticator app are all digits; anything else is
+	// treated as a recovery code.
+	method := "totp"
+	if len(form.Code) == totp.Digits && isDigits(form.Code) {
+		err = app.Database.VerifyTOTP(userID, form.Code)
+	} else {
+		method = "recovery code"
+		err = app.Database.UseRecoveryCode(userID, form.Code)
+	}
+	if err == models.ErrInvalidCredentials {
+		app.audit(r, 0, "login.failure", userTarget(userID), "invalid "+method)
+		app.countLogin(false)
+		err = app.loginFailed(r, user.Email)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+
+		form.Failures["Code"] = "Code is incorrect"
+		app.RenderHTML(w, r, "login-totp.page.html", &HTMLData{Form: form})
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.loginSucceeded(user.Email)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	err = session.Remove(w, "pendingUserID")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = app.logIn(w, r, userID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.audit(r, userID, "login.success", userTarget(userID), method)
+	app.countLogin(true)
+
+	http.Redirect(w, r, "/snippet/new", http.StatusSeeOther)
+}
+
+// startTOTPLogin remembers that the user has passed the first step of logging
+// in, whether with their password or through single sign-on, so that they can
+// be asked for their one-time code.
+func (app *App) startTOTPLogin(w http.ResponseWriter, r *http.Request, userID int) error {
+	session := app.Sessions.Load(r)
+	err := session.PutInt(w, "pendingUserID", userID)
+	if err != nil {
+		return err
+	}
+	return session.PutTime(w, "pendingUserTime", time.Now())
+}
+
+// pendingUserID returns the ID of the user who has entered their password (or
+// logged in through single sign-on) but not yet their one-time code, or 0 if
+// there is no such login in progress or it has timed out.
+func (app *App) pendingUserID(w http.ResponseWriter, r *http.Request) (int, error) {
+	session := app.Sessions.Load(r)
+	userID, err := session.GetInt("pendingUserID")
+	if err != nil || userID == 0 {
+		return 0, err
+	}
+
+	started, err := session.GetTime("pendingUserTime")
+	if err != nil {
+		return 0, err
+	}
+	if time.Since(started) > pendingLoginTimeout {
+		return 0, session.Remove(w, "pendingUserID")
+	}
+
+	return userID, nil
+}
+
+// renderTOTPSetup renders the setup page with the secret encoded as a QR code.
+func (app *App) renderTOTPSetup(w http.ResponseWriter, r *http.Request, user *models.User, secret string, form *forms.TOTPCode) {
+	png, err := qrcode.Encode(totp.URL("Snippetbox", user.Email, secret), qrcode.Medium, 256)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.RenderHTML(w, r, "totp-setup.page.html", &HTMLData{
+		Form:       form,
+		QRCode:     template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+		TOTPSecret: secret,
+	})
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -2,26 +2,51 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
 	"net/http"
-	"path/filepath"
+	"net/url"
 	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/vermeerp/snippetbox/pkg/forms"
 	"github.com/vermeerp/snippetbox/pkg/models" // New import
+	"github.com/vermeerp/snippetbox/pkg/secrets"
 )
 
 // HTMLData struct acts as a wrapper for the dynamic data we want
 // to pass to our templates. For now this just contains the snippet data that we
 // want to display, which has the underling type *models.Snippet.
 type HTMLData struct {
-	CSRFToken string
-	Flash     string
-	Form      interface{}
-	LoggedIn  bool
-	Path      string
-	Snippet   *models.Snippet
-	Snippets  []*models.Snippet
+	AuditEvents       []*models.AuditEvent
+	CSRFToken         string
+	CurrentUser       *models.User
+	ErrorStatus       int
+	Findings          []secrets.Finding
+	Flash             string
+	Form              interface{}
+	LockedUntil       time.Time
+	LoggedIn          bool
+	OIDCEnabled       bool
+	Page              int
+	Path              string
+	QRCode            template.URL
+	Query             url.Values
+	RecoveryCodes     []string
+	Report            *models.Report
+	Reports           []*models.Report
+	RequestID         string
+	Roles             []string
+	Search            string
+	SecretPolicy      string
+	ShowResolved      bool
+	Snippet           *models.Snippet
+	Snippets          []*models.Snippet
+	TemporaryPassword string
+	TOTPSecret        string
+	User              *models.User
+	Users             []*models.User
+	UserSessions      []*models.UserSession
 }
 
 // Create a humanDate function which returns a nicely formated string
@@ -30,8 +55,29 @@ func humanDate(t time.Time) string {
 	return t.Format("02 Jan 2006 at 15:04")
 }
 
+// templateFuncs returns a string-keyed map which acts as a lookup between the
+// names of our custom template functions and the functions themselves.
+func templateFuncs(static *Static) template.FuncMap {
+	return template.FuncMap{
+		"add":         func(a, b int) int { return a + b },
+		"device":      device,
+		"humanDate":   humanDate,
+		"reasonLabel": forms.ReasonLabel,
+		"static":      static.URL,
+	}
+}
+
 // RenderHTML renders the HTML
 func (app *App) RenderHTML(w http.ResponseWriter, r *http.Request, page string, data *HTMLData) {
+	err := app.render(w, r, http.StatusOK, page, data)
+	if err != nil {
+		app.ServerError(w, r, err) // Use the new app.ServerError() helper.
+	}
+}
+
+// render renders a page with the given status code. Nothing is written if
+// it fails, so the caller can still send an error.
+func (app *App) render(w http.ResponseWriter, r *http.Request, status int, page string, data *HTMLData) error {
 	// If no data has been passed in, initialize a new empty HTMLData object.
 	if data == nil {
 		data = &HTMLData{}
@@ -43,45 +89,51 @@ func (app *App) RenderHTML(w http.ResponseWriter, r *http.Request, page string,
 	// Always add the CSRF token to the data for our templates.
 	data.CSRFToken = nosurf.Token(r)
 
+	// Let the templates know whether to offer single sign-on.
+	data.OIDCEnabled = app.OIDC != nil
+
 	// Add the logged in status to the HTMLData.
 	var err error
 	data.LoggedIn, err = app.LoggedIn(r)
 	if err != nil {
-		app.ServerError(w, err)
-		return
+		return err
 	}
 
-	files := []string{
-		filepath.Join(app.HTMLDir, "base.html"),
-		filepath.Join(app.HTMLDir, page),
+	// Add the logged in user, so that the templates can show links depending
+	// on their role.
+	if data.LoggedIn {
+		data.CurrentUser, err = app.CurrentUser(r)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Initialize a template.FuncMap object. This is essentially a string-keyed map
-	// which acts as a lookup between the names of our custom template functions and
-	// the functions themselves.
-	fm := template.FuncMap{
-		"humanDate": humanDate,
-	}
+	start := time.Now()
+	defer func() {
+		app.Metrics.RenderDuration.WithLabelValues(page).Observe(time.Since(start).Seconds())
+	}()
 
-	ts, err := template.New("").Funcs(fm).ParseFiles(files...)
-	if err != nil {
-		app.ServerError(w, err) // Use the new app.ServerError() helper.
-		return
+	// Look up the page in the template cache.
+	ts := app.Templates.Get(page)
+	if ts == nil {
+		return fmt.Errorf("the template %s does not exist", page)
 	}
+
 	// Initialize a new buffer.
 	buf := new(bytes.Buffer)
 
 	// Write the template to the buffer, instead of straight to the
-	// http.ResponseWriter. If there's an error, call our error handler and then
-	// return.
-	err = ts.ExecuteTemplate(buf, "base", data)
+	// http.ResponseWriter. If there's an error, return it without having
+	// written anything.
+	err = ts.ExecuteTemplate(buf, "layout", data)
 	if err != nil {
-		app.ServerError(w, err)
-		return
+		return err
 	}
 
 	// Write the contents of the buffer to the http.ResponseWriter. Again, this
 	// is another time where we pass our http.ResponseWriter to a function that
 	// takes an io.Writer.
+	w.WriteHeader(status)
 	buf.WriteTo(w)
+	return nil
 }
@@ -0,0 +1,9 @@
+-- New users start out unverified and are sent a link containing a random
+-- token. Only the SHA-256 hash of the token is stored.
+ALTER TABLE users
+    ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN verification_token CHAR(64) NULL,
+    ADD COLUMN verification_sent DATETIME NULL;
+
+-- Accounts which existed before verification was introduced are trusted.
+UPDATE users SET verified = TRUE;
@@ -0,0 +1,16 @@
+-- Opt-in TOTP two-factor authentication. totp_last_step records the time step
+-- of the last accepted code, so that a code can't be used twice.
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(32) NULL,
+    ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Single-use recovery codes, stored as SHA-256 hashes.
+CREATE TABLE recovery_codes (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recovery_codes_user ON recovery_codes(user_id);
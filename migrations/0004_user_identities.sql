@@ -0,0 +1,11 @@
+-- Identities at external OpenID Connect providers, linked to local users.
+CREATE TABLE user_identities (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+ALTER TABLE user_identities ADD CONSTRAINT user_identities_uc_subject UNIQUE (issuer, subject);
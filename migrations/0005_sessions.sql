@@ -0,0 +1,23 @@
+-- Session data, managed by scs's mysqlstore.
+CREATE TABLE sessions (
+    token CHAR(43) PRIMARY KEY,
+    data BLOB NOT NULL,
+    expiry TIMESTAMP(6) NOT NULL
+);
+
+CREATE INDEX sessions_expiry_idx ON sessions (expiry);
+
+-- Logged in sessions, so that users can see where they're logged in and
+-- revoke sessions. The id is the SHA-256 hash of a random token which is kept
+-- in the session data.
+CREATE TABLE user_sessions (
+    id CHAR(64) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_sessions_user ON user_sessions(user_id);
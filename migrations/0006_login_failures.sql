@@ -0,0 +1,8 @@
+-- Failed login attempts, counted per email address ("email:<address>") and per
+-- client IP address ("ip:<address>").
+CREATE TABLE login_failures (
+    name VARCHAR(300) NOT NULL PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure DATETIME NOT NULL,
+    locked_until DATETIME NULL
+);
@@ -0,0 +1,9 @@
+-- Snippets now belong to the user who created them. Snippets created before
+-- this change have no owner.
+ALTER TABLE snippets
+    ADD COLUMN user_id INTEGER NULL,
+    ADD FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+-- Users can ask for their account to be deleted. It is removed, along with
+-- their snippets, once a cooldown period has passed.
+ALTER TABLE users ADD COLUMN deletion_requested DATETIME NULL;
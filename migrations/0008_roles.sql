@@ -0,0 +1,7 @@
+-- User roles ('user', 'moderator' or 'admin') and disabled accounts. Promote
+-- the first admin by hand:
+--
+--     UPDATE users SET role = 'admin' WHERE email = 'you@example.com';
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user',
+    ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,24 @@
+-- Moderators can hide snippets, which removes them from public view without
+-- deleting them.
+ALTER TABLE snippets ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Abuse reports about snippets, and the moderator's decision on them. Reports
+-- are kept after their snippet has been deleted, as a record of the decision,
+-- so snippet_id isn't a foreign key.
+CREATE TABLE reports (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    reporter_id INTEGER NULL,
+    reason VARCHAR(32) NOT NULL,
+    details TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    decision VARCHAR(16) NULL,
+    notes TEXT NULL,
+    moderator_id INTEGER NULL,
+    resolved DATETIME NULL,
+    FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (moderator_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_reports_snippet ON reports(snippet_id);
+CREATE INDEX idx_reports_resolved ON reports(resolved);
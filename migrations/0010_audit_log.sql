@@ -0,0 +1,23 @@
+-- Append-only log of security-relevant events. There are no foreign keys, so
+-- that entries outlive the users and snippets they refer to, and triggers stop
+-- entries from being changed or removed.
+CREATE TABLE audit_log (
+    id BIGINT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    created DATETIME(6) NOT NULL,
+    event VARCHAR(64) NOT NULL,
+    actor_id INTEGER NULL,
+    target VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    details TEXT NOT NULL
+);
+
+CREATE INDEX idx_audit_log_created ON audit_log(created);
+CREATE INDEX idx_audit_log_event ON audit_log(event);
+CREATE INDEX idx_audit_log_actor ON audit_log(actor_id);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log FOR EACH ROW
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log FOR EACH ROW
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
@@ -0,0 +1,13 @@
+-- Records which migrations have been applied, so that the server can tell
+-- whether the schema is up to date. Each migration from here on ends by
+-- recording its own version.
+CREATE TABLE schema_migrations (
+    version INTEGER NOT NULL PRIMARY KEY,
+    applied DATETIME NOT NULL
+);
+
+INSERT INTO schema_migrations (version, applied) VALUES
+    (1, UTC_TIMESTAMP()), (2, UTC_TIMESTAMP()), (3, UTC_TIMESTAMP()),
+    (4, UTC_TIMESTAMP()), (5, UTC_TIMESTAMP()), (6, UTC_TIMESTAMP()),
+    (7, UTC_TIMESTAMP()), (8, UTC_TIMESTAMP()), (9, UTC_TIMESTAMP()),
+    (10, UTC_TIMESTAMP()), (11, UTC_TIMESTAMP());
@@ -0,0 +1,9 @@
+-- A changed email address is kept aside until the user follows the link sent
+-- to it, so that an account can't be moved to an address its owner doesn't
+-- control. As with verification, only the SHA-256 hash of the token is stored.
+ALTER TABLE users
+    ADD COLUMN pending_email VARCHAR(255) NULL,
+    ADD COLUMN email_change_token CHAR(64) NULL,
+    ADD COLUMN email_change_sent DATETIME NULL;
+
+INSERT INTO schema_migrations (version, applied) VALUES (12, UTC_TIMESTAMP());
@@ -0,0 +1,38 @@
+// Package migrations embeds the database schema migrations, so that the server
+// knows which version of the schema it expects.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Files holds the migrations, named NNNN_description.sql.
+//
+//go:embed *.sql
+var Files embed.FS
+
+// Latest returns the version of the newest migration.
+func Latest() (int, error) {
+	names, err := fs.Glob(Files, "*.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	latest := 0
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return 0, fmt.Errorf("migrations: bad file name %q", name)
+		}
+		if version > latest {
+			latest = version
+		}
+	}
+
+	return latest, nil
+}
@@ -11,10 +11,11 @@ var rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9
 // NewSnippet holds the form values (and also a map to hold any validation
 // failure messages).
 type NewSnippet struct {
-	Title    string
-	Content  string
-	Expires  string
-	Failures map[string]string
+	Title          string
+	Content        string
+	Expires        string
+	ConfirmSecrets bool // the author has confirmed that the content is safe to publish
+	Failures       map[string]string
 }
 
 // Valid method which carries out validation checks on the form
@@ -100,3 +101,196 @@ func (f *LoginUser) Valid() bool {
 
 	return len(f.Failures) == 0
 }
+
+// TOTPCode contains a one-time code entered by the user, either from their
+// authenticator app or one of their recovery codes.
+type TOTPCode struct {
+	Code     string
+	Failures map[string]string
+}
+
+// Valid validates TOTPCode data
+func (f *TOTPCode) Valid() bool {
+	f.Failures = make(map[string]string)
+
+	if strings.TrimSpace(f.Code) == "" {
+		f.Failures["Code"] = "Code is required"
+	}
+
+	return len(f.Failures) == 0
+}
+
+// ConfirmPassword contains the current password of the user, for confirming
+// sensitive actions. Reauthenticated is set instead if the user has just
+// confirmed who they are through single sign-on.
+type ConfirmPassword struct {
+	Password        string
+	Reauthenticated bool
+	Failures        map[string]string
+}
+
+// Valid validates ConfirmPassword data
+func (f *ConfirmPassword) Valid() bool {
+	f.Failures = make(map[string]string)
+
+	if !f.Reauthenticated && strings.TrimSpace(f.Password) == "" {
+		f.Failures["Password"] = "Password is required"
+	}
+
+	return len(f.Failures) == 0
+}
+
+// ChangeName contains the new display name of the user.
+type ChangeName struct {
+	Name     string
+	Failures map[string]string
+}
+
+// Valid validates ChangeName data
+func (f *ChangeName) Valid() bool {
+	f.Failures = make(map[string]string)
+
+	if strings.TrimSpace(f.Name) == "" {
+		f.Failures["Name"] = "Name is required"
+	} else if utf8.RuneCountInString(f.Name) > 255 {
+		f.Failures["Name"] = "Name cannot be longer than 255 characters"
+	}
+
+	return len(f.Failures) == 0
+}
+
+// ChangeEmail contains the new email address of the user, along with their
+// current password to confirm the change (unless Reauthenticated is set).
+type ChangeEmail struct {
+	Email           string
+	Password        string
+	Reauthenticated bool
+	Failures        map[string]string
+}
+
+// Valid validates ChangeEmail data
+func (f *ChangeEmail) Valid() bool {
+	f.Failures = make(map[string]string)
+
+	if strings.TrimSpace(f.Email) == "" {
+		f.Failures["Email"] = "Email is required"
+	} else if len(f.Email) > 254 || !rxEmail.MatchString(f.Email) {
+		f.Failures["Email"] = "Email is not a valid address"
+	}
+
+	if !f.Reauthenticated && strings.TrimSpace(f.Password) == "" {
+		f.Failures["Password"] = "Password is required"
+	}
+
+	return len(f.Failures) == 0
+}
+
+// ChangePassword contains the current and new passwords of the user. The
+// current password isn't needed if Reauthenticated is set, so that users who
+// log in through single sign-on can set one.
+type ChangePassword struct {
+	CurrentPassword string
+	NewPassword     string
+	ConfirmPassword string
+	Reauthenticated bool
+	Failures        map[string]string
+}
+
+// Valid validates ChangePassword data
+func (f *ChangePassword) Valid() bool {
+	f.Failures = make(map[string]string)
+
+	if !f.Reauthenticated && strings.TrimSpace(f.CurrentPassword) == "" {
+		f.Failures["CurrentPassword"] = "Current password is required"
+	}
+
+	if utf8.RuneCountInString(f.NewPassword) < 8 {
+		f.Failures["NewPassword"] = "Password cannot be shorter than 8 characters"
+	} else if f.NewPassword != f.ConfirmPassword {
+		f.Failures["ConfirmPassword"] = "Passwords do not match"
+	}
+
+	return len(f.Failures) == 0
+}
+
+// Option is a value which can be chosen in a form, along with its label.
+type Option struct {
+	Value string
+	Label string
+}
+
+// ReportReasons are the categories a snippet can be reported under.
+var ReportReasons = []Option{
+	{"secret", "Contains leaked credentials or secrets"},
+	{"personal", "Contains personal information"},
+	{"abuse", "Abusive or harassing content"},
+	{"illegal", "Illegal content"},
+	{"spam", "Spam"},
+	{"other", "Something else"},
+}
+
+// ReasonLabel returns the label of a report reason.
+func ReasonLabel(reason string) string {
+	for _, o := range ReportReasons {
+		if o.Value == reason {
+			return o.Label
+		}
+	}
+	return reason
+}
+
+// ReportSnippet contains an abuse report about a snippet.
+type ReportSnippet struct {
+	Reason   string
+	Details  string
+	Failures map[string]string
+}
+
+// Reasons returns the categories the snippet can be reported under.
+func (f *ReportSnippet) Reasons() []Option {
+	return ReportReasons
+}
+
+// Valid validates ReportSnippet data
+func (f *ReportSnippet) Valid() bool {
+	f.Failures = make(map[string]string)
+
+	permitted := make(map[string]bool)
+	for _, o := range ReportReasons {
+		permitted[o.Value] = true
+	}
+	if !permitted[f.Reason] {
+		f.Failures["Reason"] = "Please choose a reason"
+	}
+
+	if f.Reason == "other" && strings.TrimSpace(f.Details) == "" {
+		f.Failures["Details"] = "Please tell us what's wrong"
+	} else if utf8.RuneCountInString(f.Details) > 1000 {
+		f.Failures["Details"] = "Details cannot be longer than 1000 characters"
+	}
+
+	return len(f.Failures) == 0
+}
+
+// ModerateReport contains a moderator's decision on a reported snippet.
+type ModerateReport struct {
+	Decision string
+	Notes    string
+	Failures map[string]string
+}
+
+// Valid validates ModerateReport data
+func (f *ModerateReport) Valid() bool {
+	f.Failures = make(map[string]string)
+
+	permitted := map[string]bool{"dismiss": true, "hide": true, "delete": true}
+	if !permitted[f.Decision] {
+		f.Failures["Decision"] = "Please choose a decision"
+	}
+
+	if utf8.RuneCountInString(f.Notes) > 2000 {
+		f.Failures["Notes"] = "Notes cannot be longer than 2000 characters"
+	}
+
+	return len(f.Failures) == 0
+}
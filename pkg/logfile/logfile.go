@@ -0,0 +1,106 @@
+// Package logfile provides a log file which rotates itself once it grows past
+// a given size.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is an io.Writer appending to the file at Path. Once a write would take
+// it past MaxSize bytes, the file is renamed to Path.1 (moving any older
+// backups up to Path.2 and so on, and deleting the oldest beyond MaxBackups)
+// and a new one is started. It is safe for concurrent use.
+type File struct {
+	Path       string
+	MaxSize    int64 // 0 means never rotate
+	MaxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens, or creates, the log file at path.
+func Open(path string, maxSize int64, maxBackups int) (*File, error) {
+	lf := &File{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	err := lf.open()
+	if err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+// Write appends p to the file, rotating it first if needed. A single write is
+// never split across two files.
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.MaxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.MaxSize {
+		err := lf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	return lf.f.Close()
+}
+
+func (lf *File) open() error {
+	f, err := os.OpenFile(lf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	lf.f = f
+	lf.size = info.Size()
+	return nil
+}
+
+func (lf *File) rotate() error {
+	err := lf.f.Close()
+	if err != nil {
+		return err
+	}
+
+	// Shift the backups along, dropping the oldest. With no backups the
+	// current file is simply truncated.
+	if lf.MaxBackups > 0 {
+		for i := lf.MaxBackups - 1; i > 0; i-- {
+			err = os.Rename(lf.backup(i), lf.backup(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = os.Rename(lf.Path, lf.backup(1))
+	} else {
+		err = os.Remove(lf.Path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return lf.open()
+}
+
+func (lf *File) backup(n int) string {
+	return fmt.Sprintf("%s.%d", lf.Path, n)
+}
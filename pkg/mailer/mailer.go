@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer is implemented by anything that can deliver a plain-text email. The
+// application only depends on this interface, so the delivery mechanism can be
+// swapped out without touching the handlers.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// LogMailer doesn't deliver anything; it writes each message to the standard
+// logger instead. It's intended for development, where no SMTP server is
+// available.
+type LogMailer struct{}
+
+// Send writes the message to the log.
+func (m *LogMailer) Send(to, subject, body string) error {
+	log.Printf("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// SMTPMailer delivers messages through an SMTP server using PLAIN auth (when a
+// Username is set).
+type SMTPMailer struct {
+	Addr     string // host:port of the SMTP server
+	From     string
+	Username string
+	Password string
+}
+
+// Send delivers the message through the configured SMTP server.
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	// Build a minimal RFC 5322 message. The header values come from our own
+	// code and a validated email address, but we still strip any line breaks
+	// to rule out header injection.
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		stripNewlines(m.From), stripNewlines(to), stripNewlines(subject), body)
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(msg))
+}
+
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
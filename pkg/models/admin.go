@@ -0,0 +1,116 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// ListUsers returns a page of users whose name or email address contains the
+// query (or all users, if it is empty), ordered by ID.
+func (db *Database) ListUsers(query string, limit, offset int) ([]*User, error) {
+	// Escape the LIKE wildcards, so that they are matched literally.
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+
+	stmt := `SELECT ` + userColumns + ` FROM users
+    WHERE name LIKE ? OR email LIKE ? ORDER BY id LIMIT ? OFFSET ?`
+
+	rows, err := db.Query(stmt, pattern, pattern, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SetUserDisabled disables or re-enables the user's account.
+func (db *Database) SetUserDisabled(id int, disabled bool) error {
+	_, err := db.Exec("UPDATE users SET disabled = ? WHERE id = ?", disabled, id)
+	return err
+}
+
+// SetUserRole changes the role of the user.
+func (db *Database) SetUserRole(id int, role string) error {
+	if roleRank(role) < 0 {
+		return ErrInvalidRole
+	}
+
+	_, err := db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	return err
+}
+
+// ListSnippets returns a page of all snippets, including expired ones, newest
+// first.
+func (db *Database) ListSnippets(limit, offset int) (Snippets, error) {
+	stmt := `SELECT id, user_id, title, content, created, expires, hidden FROM snippets
+    ORDER BY created DESC LIMIT ? OFFSET ?`
+
+	rows, err := db.Query(stmt, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := Snippets{}
+	for rows.Next() {
+		s := &Snippet{}
+		var userID sql.NullInt64
+		err := rows.Scan(&s.ID, &userID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Hidden)
+		if err != nil {
+			return nil, err
+		}
+		s.UserID = int(userID.Int64)
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// GetAnySnippet returns the snippet with the given ID, even if it has expired,
+// or nil if there is no such snippet.
+func (db *Database) GetAnySnippet(id int) (*Snippet, error) {
+	stmt := `SELECT id, user_id, title, content, created, expires, hidden FROM snippets
+    WHERE id = ?`
+
+	s := &Snippet{}
+	var userID sql.NullInt64
+	err := db.QueryRow(stmt, id).Scan(&s.ID, &userID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Hidden)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s.UserID = int(userID.Int64)
+
+	return s, nil
+}
+
+// SetSnippetHidden hides the snippet from public view, or makes it visible
+// again.
+func (db *Database) SetSnippetHidden(id int, hidden bool) error {
+	_, err := db.Exec("UPDATE snippets SET hidden = ? WHERE id = ?", hidden, id)
+	return err
+}
+
+// DeleteSnippet removes the snippet with the given ID.
+func (db *Database) DeleteSnippet(id int) error {
+	_, err := db.Exec("DELETE FROM snippets WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// InsertAuditEvent appends an entry to the audit log. Entries can never be
+// changed or deleted.
+func (db *Database) InsertAuditEvent(e *AuditEvent) error {
+	if len(e.UserAgent) > 255 {
+		e.UserAgent = e.UserAgent[:255]
+	}
+	if len(e.Target) > 255 {
+		e.Target = e.Target[:255]
+	}
+
+	stmt := `INSERT INTO audit_log (created, event, actor_id, target, ip, user_agent, details)
+    VALUES(UTC_TIMESTAMP(6), ?, ?, ?, ?, ?, ?)`
+
+	actorID := sql.NullInt64{Int64: int64(e.ActorID), Valid: e.ActorID != 0}
+	_, err := db.Exec(stmt, e.Event, actorID, e.Target, e.IP, e.UserAgent, e.Details)
+	return err
+}
+
+// ListAuditEvents returns a page of the audit log entries matching the filter,
+// newest first.
+func (db *Database) ListAuditEvents(f AuditFilter, limit, offset int) ([]*AuditEvent, error) {
+	events := []*AuditEvent{}
+	err := db.queryAuditEvents(f, " LIMIT ? OFFSET ?", []interface{}{limit, offset}, func(e *AuditEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// EachAuditEvent calls fn for every audit log entry matching the filter, oldest
+// first, stopping at the first error. It is meant for exporting the log, which
+// may be too large to hold in memory.
+func (db *Database) EachAuditEvent(f AuditFilter, fn func(*AuditEvent) error) error {
+	return db.queryAuditEvents(f, "", nil, fn)
+}
+
+func (db *Database) queryAuditEvents(f AuditFilter, limit string, limitArgs []interface{}, fn func(*AuditEvent) error) error {
+	var where []string
+	var args []interface{}
+	if f.Event != "" {
+		where = append(where, "event LIKE ?")
+		args = append(args, strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Event)+"%")
+	}
+	if f.ActorID != 0 {
+		where = append(where, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, f.Until.UTC())
+	}
+
+	stmt := `SELECT id, created, event, actor_id, target, ip, user_agent, details FROM audit_log`
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Pages are shown newest first, while exports run oldest first.
+	if limit != "" {
+		stmt += " ORDER BY id DESC" + limit
+	} else {
+		stmt += " ORDER BY id"
+	}
+
+	rows, err := db.Query(stmt, append(args, limitArgs...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := &AuditEvent{}
+		var actorID sql.NullInt64
+		err := rows.Scan(&e.ID, &e.Created, &e.Event, &actorID, &e.Target, &e.IP, &e.UserAgent, &e.Details)
+		if err != nil {
+			return err
+		}
+		e.ActorID = int(actorID.Int64)
+
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
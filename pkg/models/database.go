@@ -1,10 +1,18 @@
 package models
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/vermeerp/snippetbox/pkg/totp"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -12,6 +20,12 @@ import (
 var (
 	ErrDuplicateEmail     = errors.New("models: email address already in use")
 	ErrInvalidCredentials = errors.New("models: invalid user credentials")
+	ErrInvalidToken       = errors.New("models: invalid or expired token")
+	ErrNoRecord           = errors.New("models: no matching record found")
+	ErrAccountDisabled    = errors.New("models: account disabled")
+	ErrInvalidRole        = errors.New("models: invalid role")
+	ErrUnverifiedAccount  = errors.New("models: account not verified")
+	ErrIdentityLinked     = errors.New("models: identity linked to another user")
 )
 
 // Database type (for now it's just an empty struct).
@@ -25,7 +39,7 @@ type Database struct {
 // passed to the method equals 123, or returns nil otherwise.
 func (db *Database) GetSnippet(id int) (*Snippet, error) {
 	stmt := `SELECT id, title, content, created, expires FROM snippets
-    WHERE expires > UTC_TIMESTAMP() AND id = ?`
+    WHERE expires > UTC_TIMESTAMP() AND hidden = FALSE AND id = ?`
 
 	row := db.QueryRow(stmt, id)
 
@@ -42,18 +56,18 @@ func (db *Database) GetSnippet(id int) (*Snippet, error) {
 
 }
 
-// InsertSnippet adds a snippet to the database
-func (db *Database) InsertSnippet(title, content, expires string) (int, error) {
+// InsertSnippet adds a snippet owned by the given user to the database
+func (db *Database) InsertSnippet(userID int, title, content, expires string) (int, error) {
 	// Write the SQL statement we want to execute.
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-    VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	stmt := `INSERT INTO snippets (user_id, title, content, created, expires)
+    VALUES(?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
 
 	// Use the db.Exec() method to execute the statement snippet, passing in values
 	// for our (untrusted) title, content and expiry placeholder parameters in
 	// exactly the same way that we did with the QueryRow() method. This returns
 	// a sql.Result object, which contains some basic information about what
 	// happened when the statement was executed.
-	result, err := db.Exec(stmt, title, content, expires)
+	result, err := db.Exec(stmt, userID, title, content, expires)
 	if err != nil {
 		return 0, err
 	}
@@ -74,7 +88,7 @@ func (db *Database) InsertSnippet(title, content, expires string) (int, error) {
 func (db *Database) LatestSnippets() (Snippets, error) {
 	// Write the SQL statement we want to execute.
 	stmt := `SELECT id, title, content, created, expires FROM snippets
-    WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
+    WHERE expires > UTC_TIMESTAMP() AND hidden = FALSE ORDER BY created DESC LIMIT 10`
 
 	// Use the QueryRow() method on the embedded connection pool to execute our
 	// SQL statement. This results a sql.Rows resultset containing the result of
@@ -132,28 +146,65 @@ func (db *Database) LatestSnippets() (Snippets, error) {
 	return snippets, nil
 }
 
-// InsertUser inserts a user into the database
-func (db *Database) InsertUser(name, email, password string) error {
+// UserSnippets returns all of the snippets owned by the user, including expired
+// ones, oldest first.
+func (db *Database) UserSnippets(userID int) (Snippets, error) {
+	stmt := `SELECT id, user_id, title, content, created, expires FROM snippets
+    WHERE user_id = ? ORDER BY created`
+
+	rows, err := db.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := Snippets{}
+	for rows.Next() {
+		s := &Snippet{}
+		err := rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// InsertUser inserts a new, unverified user into the database and returns its
+// ID.
+func (db *Database) InsertUser(name, email, password string) (int, error) {
 	// Create a bcrypt hash of the plain-text password.
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	stmt := `INSERT INTO users (name, email, password, created)
     VALUES(?, ?, ?, UTC_TIMESTAMP())`
 
 	// Insert the user details and hashed password into the users table. If there
-	// we type assert it to a *mysql.MySQLError object so we can check its
-	// specific error number. If it's error 1062 we return the ErrDuplicateEmail
+	// is an error and it's a *mysql.MySQLError, we check its specific error
+	// number. If it's error 1062 we return the ErrDuplicateEmail
 	// error instead of the one from MySQL.
-	_, err = db.Exec(stmt, name, email, string(hashedPassword))
+	result, err := db.Exec(stmt, name, email, string(hashedPassword))
 	if err != nil {
-		if err.(*mysql.MySQLError).Number == 1062 {
-			return ErrDuplicateEmail
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return 0, ErrDuplicateEmail
 		}
+		return 0, err
 	}
-	return err
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
 }
 
 // VerifyUser validates the email and password as a user
@@ -162,8 +213,9 @@ func (db *Database) VerifyUser(email, password string) (int, error) {
 	// matching email exists, we return the ErrInvalidCredentials error.
 	var id int
 	var hashedPassword []byte
-	row := db.QueryRow("SELECT id, password FROM users WHERE email = ?", email)
-	err := row.Scan(&id, &hashedPassword)
+	var disabled bool
+	row := db.QueryRow("SELECT id, password, disabled FROM users WHERE email = ?", email)
+	err := row.Scan(&id, &hashedPassword, &disabled)
 	if err == sql.ErrNoRows {
 		return 0, ErrInvalidCredentials
 	} else if err != nil {
@@ -179,6 +231,651 @@ func (db *Database) VerifyUser(email, password string) (int, error) {
 		return 0, err
 	}
 
+	// Only tell the user their account is disabled once they've proved it's
+	// theirs.
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+
 	// Otherwise, the password is correct. Return the user ID.
 	return id, nil
 }
+
+// VerifyPassword checks the password of the user with the given ID, returning
+// ErrInvalidCredentials if it doesn't match.
+func (db *Database) VerifyPassword(id int, password string) error {
+	var hashedPassword []byte
+	row := db.QueryRow("SELECT password FROM users WHERE id = ?", id)
+	err := row.Scan(&hashedPassword)
+	if err == sql.ErrNoRows {
+		return ErrInvalidCredentials
+	} else if err != nil {
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// UpdateUserName changes the display name of the user.
+func (db *Database) UpdateUserName(id int, name string) error {
+	_, err := db.Exec("UPDATE users SET name = ? WHERE id = ?", name, id)
+	return err
+}
+
+// RequestEmailChange records that the user wants to change their email
+// address and returns a token for confirming it with ConfirmEmailChange. The
+// address in use stays as it is until then. Only a hash of the token is
+// stored, so the plain-text value returned here is the only copy of it.
+func (db *Database) RequestEmailChange(id int, email string) (string, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT true FROM users WHERE email = ?)", email).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", ErrDuplicateEmail
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `UPDATE users SET pending_email = ?, email_change_token = ?,
+    email_change_sent = UTC_TIMESTAMP() WHERE id = ?`
+	_, err = db.Exec(stmt, email, hash, id)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConfirmEmailChange swaps in the pending email address of the user owning the
+// given token, marking it as verified since they have just proved they can read
+// its mail, and returns the user's ID and their old and new addresses. Tokens
+// are valid for 24 hours after they were issued; unknown or expired tokens
+// result in ErrInvalidToken. If somebody else has taken the address in the
+// meantime ErrDuplicateEmail is returned.
+func (db *Database) ConfirmEmailChange(token string) (int, string, string, error) {
+	var id int
+	var oldEmail, newEmail string
+	stmt := `SELECT id, email, pending_email FROM users WHERE email_change_token = ?
+    AND email_change_sent > DATE_SUB(UTC_TIMESTAMP(), INTERVAL 24 HOUR)`
+	err := db.QueryRow(stmt, hashToken(token)).Scan(&id, &oldEmail, &newEmail)
+	if err == sql.ErrNoRows {
+		return 0, "", "", ErrInvalidToken
+	} else if err != nil {
+		return 0, "", "", err
+	}
+
+	stmt = `UPDATE users SET email = pending_email, verified = TRUE, verification_token = NULL,
+    pending_email = NULL, email_change_token = NULL, email_change_sent = NULL WHERE id = ?`
+	_, err = db.Exec(stmt, id)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return 0, "", "", ErrDuplicateEmail
+	} else if err != nil {
+		return 0, "", "", err
+	}
+
+	return id, oldEmail, newEmail, nil
+}
+
+// UpdatePassword replaces the password of the user.
+func (db *Database) UpdatePassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE users SET password = ? WHERE id = ?", string(hashedPassword), id)
+	return err
+}
+
+// userColumns are the columns selected from the users table by scanUser.
+const userColumns = `id, name, email, created, verified, verification_sent, totp_enabled,
+    deletion_requested, role, disabled, pending_email, email_change_sent`
+
+// scanUser copies a row selected with userColumns into a new User.
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	u := &User{}
+	var sent, deletionRequested, emailChangeSent sql.NullTime
+	var pendingEmail sql.NullString
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Verified, &sent,
+		&u.TOTPEnabled, &deletionRequested, &u.Role, &u.Disabled, &pendingEmail,
+		&emailChangeSent)
+	if err != nil {
+		return nil, err
+	}
+	u.VerificationSent = sent.Time
+	u.DeletionRequested = deletionRequested.Time
+
+	// Pending changes are forgotten once the link has expired.
+	if pendingEmail.Valid && time.Since(emailChangeSent.Time) < 24*time.Hour {
+		u.PendingEmail = pendingEmail.String
+	}
+
+	return u, nil
+}
+
+// GetUser returns the user with the given ID, or nil if there is no such user.
+func (db *Database) GetUser(id int) (*User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+
+	u, err := scanUser(db.QueryRow(stmt, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// GetUserByEmail returns the user with the given email address, or nil if
+// there is no such user.
+func (db *Database) GetUserByEmail(email string) (*User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE email = ?`
+
+	u, err := scanUser(db.QueryRow(stmt, email))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// RequestDeletion schedules the user's account for deletion.
+func (db *Database) RequestDeletion(id int) error {
+	stmt := `UPDATE users SET deletion_requested = UTC_TIMESTAMP() WHERE id = ?`
+	_, err := db.Exec(stmt, id)
+	return err
+}
+
+// CancelDeletion cancels a scheduled deletion of the user's account.
+func (db *Database) CancelDeletion(id int) error {
+	stmt := `UPDATE users SET deletion_requested = NULL WHERE id = ?`
+	_, err := db.Exec(stmt, id)
+	return err
+}
+
+// PurgeDeletedUsers deletes the accounts whose deletion was requested more than
+// cooldown ago, and returns their IDs and email addresses. Everything belonging
+// to the users, including their snippets, goes with them through ON DELETE
+// CASCADE.
+func (db *Database) PurgeDeletedUsers(cooldown time.Duration) ([]*User, error) {
+	stmt := `SELECT id, email FROM users
+    WHERE deletion_requested < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)`
+
+	rows, err := db.Query(stmt, int(cooldown.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		u := &User{}
+		err := rows.Scan(&u.ID, &u.Email)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		_, err = db.Exec("DELETE FROM users WHERE id = ?", u.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return users, nil
+}
+
+// CreateVerificationToken generates a new email verification token for the
+// user, replacing any previous one. Only a hash of the token is stored, so the
+// plain-text value returned here is the only copy of it.
+func (db *Database) CreateVerificationToken(userID int) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `UPDATE users SET verification_token = ?, verification_sent = UTC_TIMESTAMP()
+    WHERE id = ? AND verified = FALSE`
+
+	_, err = db.Exec(stmt, hash, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// VerifyEmail marks the user owning the given verification token as verified
+// and returns their ID. Tokens are valid for 24 hours after they were issued;
+// unknown or expired tokens result in ErrInvalidToken.
+func (db *Database) VerifyEmail(token string) (int, error) {
+	var id int
+	stmt := `SELECT id FROM users WHERE verification_token = ?
+    AND verification_sent > DATE_SUB(UTC_TIMESTAMP(), INTERVAL 24 HOUR)`
+	err := db.QueryRow(stmt, hashToken(token)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidToken
+	} else if err != nil {
+		return 0, err
+	}
+
+	stmt = `UPDATE users SET verified = TRUE, verification_token = NULL WHERE id = ?`
+	_, err = db.Exec(stmt, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// LinkIdentity returns the ID of the local user for an identity at an OpenID
+// Connect provider. Identities seen for the first time are linked to the user
+// with the same email address, or to a newly created user if there isn't one.
+// The caller must make sure the provider has verified the email address.
+//
+// Users who haven't verified their address may not own it, since anybody can
+// sign up with it, so they aren't linked and ErrUnverifiedAccount is returned.
+// They can link the identity themselves with AddIdentity once logged in.
+func (db *Database) LinkIdentity(issuer, subject, email, name string) (int, error) {
+	var id int
+	stmt := `SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?`
+	err := db.QueryRow(stmt, issuer, subject).Scan(&id)
+	if err == nil {
+		return id, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	var verified bool
+	err = db.QueryRow("SELECT id, verified FROM users WHERE email = ?", email).Scan(&id, &verified)
+	if err == sql.ErrNoRows {
+		// Users created this way get a random password which nobody knows, so
+		// they can only log in through the provider.
+		password, _, err := newToken()
+		if err != nil {
+			return 0, err
+		}
+
+		id, err = db.InsertUser(name, email, password)
+		if err != nil {
+			return 0, err
+		}
+
+		// The provider has vouched for the email address, so there's no need
+		// for the user to verify it with us as well.
+		stmt = `UPDATE users SET verified = TRUE, verification_token = NULL WHERE id = ?`
+		_, err = db.Exec(stmt, id)
+		if err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	} else if !verified {
+		return 0, ErrUnverifiedAccount
+	}
+
+	return id, db.AddIdentity(id, issuer, subject)
+}
+
+// AddIdentity links an identity at an OpenID Connect provider to the user, so
+// that they can log in through it. If the identity is already linked to
+// another user ErrIdentityLinked is returned.
+func (db *Database) AddIdentity(userID int, issuer, subject string) error {
+	var id int
+	stmt := `SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?`
+	err := db.QueryRow(stmt, issuer, subject).Scan(&id)
+	if err == nil && id == userID {
+		return nil
+	} else if err == nil {
+		return ErrIdentityLinked
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	stmt = `INSERT INTO user_identities (issuer, subject, user_id, created)
+    VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err = db.Exec(stmt, issuer, subject, userID)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+// RecordLoginFailure counts a failed login attempt against the name, which
+// identifies an account or client, and returns the number of failures so far.
+// Failures are forgotten a day after the last one.
+func (db *Database) RecordLoginFailure(name string) (int, error) {
+	stmt := `INSERT INTO login_failures (name, failures, last_failure)
+    VALUES(?, 1, UTC_TIMESTAMP())
+    ON DUPLICATE KEY UPDATE
+    failures = IF(last_failure < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY), 1, failures + 1),
+    last_failure = UTC_TIMESTAMP()`
+	_, err := db.Exec(stmt, name)
+	if err != nil {
+		return 0, err
+	}
+
+	var failures int
+	err = db.QueryRow("SELECT failures FROM login_failures WHERE name = ?", name).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// LockLogin stops the name from logging in until the given time.
+func (db *Database) LockLogin(name string, until time.Time) error {
+	stmt := `UPDATE login_failures SET locked_until = ? WHERE name = ?`
+	_, err := db.Exec(stmt, until.UTC(), name)
+	return err
+}
+
+// LoginLockedUntil returns the time until which any of the names are locked
+// out, or the zero time if none of them are.
+func (db *Database) LoginLockedUntil(names ...string) (time.Time, error) {
+	var until time.Time
+	for _, name := range names {
+		var t sql.NullTime
+		stmt := `SELECT locked_until FROM login_failures
+    WHERE name = ? AND locked_until > UTC_TIMESTAMP()`
+		err := db.QueryRow(stmt, name).Scan(&t)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return time.Time{}, err
+		}
+
+		if t.Time.After(until) {
+			until = t.Time
+		}
+	}
+
+	return until, nil
+}
+
+// ClearLoginFailures forgets the failed login attempts for the name, lifting
+// any lockout.
+func (db *Database) ClearLoginFailures(name string) error {
+	_, err := db.Exec("DELETE FROM login_failures WHERE name = ?", name)
+	return err
+}
+
+// recoveryCodeCount is the number of recovery codes issued when two-factor
+// authentication is enabled.
+const recoveryCodeCount = 10
+
+// EnableTOTP stores the TOTP secret for the user, switches on two-factor
+// authentication and returns a fresh set of recovery codes.
+func (db *Database) EnableTOTP(userID int, secret string) ([]string, error) {
+	stmt := `UPDATE users SET totp_secret = ?, totp_enabled = TRUE, totp_last_step = 0
+    WHERE id = ?`
+	_, err := db.Exec(stmt, secret, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.RegenerateRecoveryCodes(userID)
+}
+
+// DisableTOTP switches off two-factor authentication for the user and removes
+// their secret and recovery codes.
+func (db *Database) DisableTOTP(userID int) error {
+	stmt := `UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0
+    WHERE id = ?`
+	_, err := db.Exec(stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	return err
+}
+
+// VerifyTOTP checks a TOTP code for the user, returning ErrInvalidCredentials
+// if it is wrong or has already been used.
+func (db *Database) VerifyTOTP(userID int, code string) error {
+	var secret sql.NullString
+	var lastStep int64
+	stmt := `SELECT totp_secret, totp_last_step FROM users
+    WHERE id = ? AND totp_enabled = TRUE`
+	err := db.QueryRow(stmt, userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return ErrInvalidCredentials
+	} else if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(secret.String, code, time.Now(), lastStep)
+	if !ok {
+		return ErrInvalidCredentials
+	}
+
+	// Record the step of the accepted code. The condition on totp_last_step
+	// makes sure that two concurrent requests can't both use the same code.
+	stmt = `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`
+	result, err := db.Exec(stmt, step, userID, step)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with a new set and
+// returns them. Only hashes are stored, so this is the only chance to show the
+// codes to the user.
+func (db *Database) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	_, err := db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		// Format the code as four groups of four characters, which is easier
+		// to copy down by hand.
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+
+		stmt := `INSERT INTO recovery_codes (user_id, code_hash) VALUES(?, ?)`
+		_, err = db.Exec(stmt, userID, hashToken(normalizeRecoveryCode(codes[i])))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes, returning
+// ErrInvalidCredentials if it doesn't match any unused code.
+func (db *Database) UseRecoveryCode(userID int, code string) error {
+	stmt := `DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?`
+	result, err := db.Exec(stmt, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// normalizeRecoveryCode strips the separators and whitespace a user might type
+// along with a recovery code.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// CreateSession records a new logged in session for the user and returns the
+// token identifying it, which should be stored in the session data.
+func (db *Database) CreateSession(userID int, ip, userAgent string) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	stmt := `INSERT INTO user_sessions (id, user_id, ip, user_agent, created, last_seen)
+    VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())`
+	_, err = db.Exec(stmt, hash, userID, ip, userAgent)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// TouchSession checks that the session identified by the token hasn't been
+// revoked, returning ErrNoRecord if it has. To avoid a write on every request,
+// the last seen time and IP address are only updated once a minute.
+func (db *Database) TouchSession(token, ip string) error {
+	var exists bool
+	stmt := `SELECT EXISTS(SELECT 1 FROM user_sessions WHERE id = ?)`
+	err := db.QueryRow(stmt, hashToken(token)).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+
+	stmt = `UPDATE user_sessions SET last_seen = UTC_TIMESTAMP(), ip = ?
+    WHERE id = ? AND last_seen < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 MINUTE)`
+	_, err = db.Exec(stmt, ip, hashToken(token))
+	return err
+}
+
+// UserSessions returns the logged in sessions of the user, most recently used
+// first. The session identified by currentToken is flagged as Current.
+func (db *Database) UserSessions(userID int, currentToken string) ([]*UserSession, error) {
+	stmt := `SELECT id, user_id, ip, user_agent, created, last_seen FROM user_sessions
+    WHERE user_id = ? ORDER BY last_seen DESC`
+
+	rows, err := db.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	current := hashToken(currentToken)
+	sessions := []*UserSession{}
+	for rows.Next() {
+		s := &UserSession{}
+		err := rows.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.Created, &s.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		s.Current = s.ID == current
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteExpiredSessions forgets the sessions which were logged in longer ago
+// than lifetime, and so have expired along with their session data, returning
+// how many there were.
+func (db *Database) DeleteExpiredSessions(lifetime time.Duration) (int64, error) {
+	stmt := `DELETE FROM user_sessions
+    WHERE created < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)`
+
+	result, err := db.Exec(stmt, int(lifetime.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteSession revokes one of the user's sessions by its ID.
+func (db *Database) DeleteSession(userID int, id string) error {
+	_, err := db.Exec("DELETE FROM user_sessions WHERE user_id = ? AND id = ?", userID, id)
+	return err
+}
+
+// DeleteSessionToken revokes the session identified by the token.
+func (db *Database) DeleteSessionToken(token string) error {
+	_, err := db.Exec("DELETE FROM user_sessions WHERE id = ?", hashToken(token))
+	return err
+}
+
+// DeleteOtherSessions revokes all of the user's sessions apart from the one
+// identified by currentToken.
+func (db *Database) DeleteOtherSessions(userID int, currentToken string) error {
+	stmt := `DELETE FROM user_sessions WHERE user_id = ? AND id <> ?`
+	_, err := db.Exec(stmt, userID, hashToken(currentToken))
+	return err
+}
+
+// DeleteUserSessions revokes all of the user's sessions.
+func (db *Database) DeleteUserSessions(userID int) error {
+	_, err := db.Exec("DELETE FROM user_sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// newToken returns a random URL-safe token along with the hex-encoded SHA-256
+// hash of it, which is what gets stored in the database.
+func newToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of a token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
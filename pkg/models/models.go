@@ -7,11 +7,120 @@ import (
 // Snippet type to hold the information about an individual snippet.
 type Snippet struct {
 	ID      int
+	UserID  int // 0 for snippets created before snippets had owners
 	Title   string
 	Content string
 	Created time.Time
 	Expires time.Time
+	Hidden  bool
 }
 
 // Snippets type, which is a slice for holding multiple Snippet objects.
 type Snippets []*Snippet
+
+// User type to hold the information about an individual user.
+type User struct {
+	ID                int
+	Name              string
+	Email             string
+	Created           time.Time
+	Verified          bool
+	VerificationSent  time.Time
+	TOTPEnabled       bool
+	DeletionRequested time.Time
+	Role              string
+	Disabled          bool
+	PendingEmail      string
+}
+
+// The roles a user can have. Each role includes the permissions of the ones
+// before it.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists the roles in order of increasing privilege.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// HasRole reports whether the user has the given role, or a more privileged
+// one.
+func (u *User) HasRole(role string) bool {
+	return RoleAtLeast(u.Role, role)
+}
+
+// RoleAtLeast reports whether role is min or a more privileged one.
+func RoleAtLeast(role, min string) bool {
+	return roleRank(role) >= roleRank(min)
+}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	return roleRank(role) >= 0
+}
+
+// roleRank returns the position of the role in Roles, or -1 for unknown roles.
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// UserSession holds the information about a session in which a user is logged
+// in.
+type UserSession struct {
+	ID        string
+	UserID    int
+	IP        string
+	UserAgent string
+	Created   time.Time
+	LastSeen  time.Time
+	Current   bool
+}
+
+// Report holds an abuse report about a snippet, and the moderator's decision
+// on it once it has been resolved.
+type Report struct {
+	ID          int
+	SnippetID   int
+	ReporterID  int
+	Reason      string
+	Details     string
+	Created     time.Time
+	Decision    string // empty while the report is open
+	Notes       string
+	ModeratorID int
+	Resolved    time.Time
+}
+
+// The decisions a moderator can make on a report.
+const (
+	DecisionDismiss = "dismiss"
+	DecisionHide    = "hide"
+	DecisionDelete  = "delete"
+)
+
+// AuditEvent holds an entry in the audit log.
+type AuditEvent struct {
+	ID        int64
+	Created   time.Time
+	Event     string
+	ActorID   int // 0 if nobody was logged in
+	Target    string
+	IP        string
+	UserAgent string
+	Details   string
+}
+
+// AuditFilter restricts the audit log entries returned by the Database. Zero
+// values don't restrict anything.
+type AuditFilter struct {
+	Event   string // matches events with this prefix, e.g. "login" or "admin."
+	ActorID int
+	Since   time.Time
+	Until   time.Time
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// reportColumns are the columns selected from the reports table by scanReport.
+const reportColumns = `id, snippet_id, reporter_id, reason, details, created, decision, notes,
+    moderator_id, resolved`
+
+// scanReport copies a row selected with reportColumns into a new Report.
+func scanReport(row interface{ Scan(...interface{}) error }) (*Report, error) {
+	r := &Report{}
+	var reporterID, moderatorID sql.NullInt64
+	var decision, notes sql.NullString
+	var resolved sql.NullTime
+	err := row.Scan(&r.ID, &r.SnippetID, &reporterID, &r.Reason, &r.Details, &r.Created,
+		&decision, &notes, &moderatorID, &resolved)
+	if err != nil {
+		return nil, err
+	}
+	r.ReporterID = int(reporterID.Int64)
+	r.Decision = decision.String
+	r.Notes = notes.String
+	r.ModeratorID = int(moderatorID.Int64)
+	r.Resolved = resolved.Time
+
+	return r, nil
+}
+
+// InsertReport records an abuse report about a snippet.
+func (db *Database) InsertReport(snippetID, reporterID int, reason, details string) error {
+	stmt := `INSERT INTO reports (snippet_id, reporter_id, reason, details, created)
+    VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+	_, err := db.Exec(stmt, snippetID, reporterID, reason, details)
+	return err
+}
+
+// GetReport returns the report with the given ID, or nil if there is no such
+// report.
+func (db *Database) GetReport(id int) (*Report, error) {
+	stmt := `SELECT ` + reportColumns + ` FROM reports WHERE id = ?`
+
+	r, err := scanReport(db.QueryRow(stmt, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// ListReports returns the open reports, oldest first, or the resolved ones,
+// most recently resolved first.
+func (db *Database) ListReports(resolved bool, limit, offset int) ([]*Report, error) {
+	stmt := `SELECT ` + reportColumns + ` FROM reports
+    WHERE resolved IS NULL ORDER BY created LIMIT ? OFFSET ?`
+	if resolved {
+		stmt = `SELECT ` + reportColumns + ` FROM reports
+    WHERE resolved IS NOT NULL ORDER BY resolved DESC LIMIT ? OFFSET ?`
+	}
+
+	return db.queryReports(stmt, limit, offset)
+}
+
+// SnippetReports returns all reports about the snippet, oldest first.
+func (db *Database) SnippetReports(snippetID int) ([]*Report, error) {
+	stmt := `SELECT ` + reportColumns + ` FROM reports
+    WHERE snippet_id = ? ORDER BY created`
+
+	return db.queryReports(stmt, snippetID)
+}
+
+// ResolveReports records the moderator's decision on all open reports about
+// the snippet.
+func (db *Database) ResolveReports(snippetID, moderatorID int, decision, notes string) error {
+	stmt := `UPDATE reports SET decision = ?, notes = ?, moderator_id = ?, resolved = UTC_TIMESTAMP()
+    WHERE snippet_id = ? AND resolved IS NULL`
+	_, err := db.Exec(stmt, decision, notes, moderatorID, snippetID)
+	return err
+}
+
+func (db *Database) queryReports(stmt string, args ...interface{}) ([]*Report, error) {
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*Report{}
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
@@ -0,0 +1,17 @@
+package models
+
+import "context"
+
+// SchemaVersion returns the version of the newest migration applied to the
+// database.
+func (db *Database) SchemaVersion(ctx context.Context) (int, error) {
+	stmt := `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+
+	var version int
+	err := db.QueryRowContext(ctx, stmt).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
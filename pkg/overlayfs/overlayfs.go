@@ -0,0 +1,77 @@
+// Package overlayfs layers one file system over another, so that individual
+// files can be replaced without copying the rest.
+package overlayfs
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// FS serves files from Upper where they exist there, and from Lower
+// otherwise. Directory listings are merged.
+type FS struct {
+	Upper fs.FS
+	Lower fs.FS
+}
+
+// New returns upper layered over lower. If upper is nil, lower is returned
+// as is.
+func New(upper, lower fs.FS) fs.FS {
+	if upper == nil {
+		return lower
+	}
+	return &FS{Upper: upper, Lower: lower}
+}
+
+// Open opens the named file from Upper if it's there, or else from Lower.
+func (o *FS) Open(name string) (fs.File, error) {
+	f, err := o.Upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.Lower.Open(name)
+	}
+	return f, err
+}
+
+// Stat returns information about the named file in Upper if it's there, or
+// else in Lower.
+func (o *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fs.Stat(o.Upper, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fs.Stat(o.Lower, name)
+	}
+	return info, err
+}
+
+// ReadDir lists the named directory in both layers, preferring the entries in
+// Upper where the names clash.
+func (o *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, upperErr := fs.ReadDir(o.Upper, name)
+	if upperErr != nil && !errors.Is(upperErr, fs.ErrNotExist) {
+		return nil, upperErr
+	}
+	lower, lowerErr := fs.ReadDir(o.Lower, name)
+	if lowerErr != nil && !errors.Is(lowerErr, fs.ErrNotExist) {
+		return nil, lowerErr
+	}
+	if upperErr != nil && lowerErr != nil {
+		return nil, lowerErr
+	}
+
+	entries := map[string]fs.DirEntry{}
+	for _, e := range lower {
+		entries[e.Name()] = e
+	}
+	for _, e := range upper {
+		entries[e.Name()] = e
+	}
+
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Name() < merged[j].Name()
+	})
+	return merged, nil
+}
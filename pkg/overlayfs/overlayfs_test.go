@@ -0,0 +1,132 @@
+package overlayfs
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestFS() fs.FS {
+	lower := fstest.MapFS{
+		"base.html":         {Data: []byte("lower base")},
+		"home.page.html":    {Data: []byte("lower home")},
+		"css/main.css":      {Data: []byte("lower css")},
+		"img/logo.png":      {Data: []byte("lower logo")},
+		"only-lower/a.html": {Data: []byte("lower a")},
+	}
+	upper := fstest.MapFS{
+		"base.html":         {Data: []byte("upper base")},
+		"extra.page.html":   {Data: []byte("upper extra")},
+		"css/main.css":      {Data: []byte("upper css")},
+		"css/theme.css":     {Data: []byte("upper theme")},
+		"only-upper/b.html": {Data: []byte("upper b")},
+	}
+	return New(upper, lower)
+}
+
+func TestOpen(t *testing.T) {
+	fsys := newTestFS()
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"base.html", "upper base"},
+		{"home.page.html", "lower home"},
+		{"extra.page.html", "upper extra"},
+		{"css/main.css", "upper css"},
+		{"css/theme.css", "upper theme"},
+		{"img/logo.png", "lower logo"},
+		{"only-lower/a.html", "lower a"},
+		{"only-upper/b.html", "upper b"},
+	}
+
+	for _, tt := range tests {
+		got, err := fs.ReadFile(fsys, tt.name)
+		if err != nil {
+			t.Errorf("ReadFile(%q): %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("ReadFile(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	_, err := newTestFS().Open("missing.html")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open of a missing file returned %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	fsys := newTestFS()
+
+	info, err := fs.Stat(fsys, "css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len("upper css")) {
+		t.Errorf("Stat(css/main.css).Size() = %d, want the upper file's size", info.Size())
+	}
+
+	_, err = fs.Stat(fsys, "missing.html")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of a missing file returned %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	fsys := newTestFS()
+
+	tests := []struct {
+		dir  string
+		want []string
+	}{
+		{".", []string{"base.html", "css", "extra.page.html", "home.page.html", "img", "only-lower", "only-upper"}},
+		{"css", []string{"main.css", "theme.css"}},
+		{"img", []string{"logo.png"}},
+		{"only-upper", []string{"b.html"}},
+	}
+
+	for _, tt := range tests {
+		entries, err := fs.ReadDir(fsys, tt.dir)
+		if err != nil {
+			t.Errorf("ReadDir(%q): %v", tt.dir, err)
+			continue
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ReadDir(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+
+	_, err := fs.ReadDir(fsys, "missing")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDir of a missing directory returned %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	got, err := fs.Glob(newTestFS(), "*.page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"extra.page.html", "home.page.html"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Glob = %q, want %q", got, want)
+	}
+}
+
+func TestNewWithoutUpper(t *testing.T) {
+	lower := fstest.MapFS{}
+	if got := New(nil, lower); !reflect.DeepEqual(got, fs.FS(lower)) {
+		t.Errorf("New(nil, lower) = %v, want lower", got)
+	}
+}
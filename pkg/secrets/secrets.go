@@ -0,0 +1,176 @@
+// Package secrets detects credentials which have been pasted into text, such
+// as cloud provider keys, private keys and other high-entropy tokens.
+package secrets
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Pattern is a kind of credential recognised by a regular expression. If the
+// expression has a capturing group, only the text it matches is treated as the
+// credential.
+type Pattern struct {
+	Kind string
+	Rx   *regexp.Regexp
+}
+
+// Patterns are the credentials recognised by Scan.
+var Patterns = []Pattern{
+	{"Private key", regexp.MustCompile(`(?s)-----BEGIN (?:[A-Z]+ )*PRIVATE KEY(?: BLOCK)?-----.*?(?:-----END (?:[A-Z]+ )*PRIVATE KEY(?: BLOCK)?-----|\z)`)},
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA)[0-9A-Z]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9\-]{10,}\b`)},
+	{"Stripe secret key", regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{24,}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{"JSON Web Token", regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{10,}\.eyJ[A-Za-z0-9_\-]{10,}\.[A-Za-z0-9_\-]{10,}`)},
+	{"Password in URL", regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.\-]*://[^\s:/@]+:([^\s:/@]{3,})@`)},
+}
+
+// rxToken matches candidate tokens for the entropy check.
+var rxToken = regexp.MustCompile(`[A-Za-z0-9+/=_\-]{20,}`)
+
+// rxHex matches tokens made up only of hex digits.
+var rxHex = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// Finding is a possible credential found in some text.
+type Finding struct {
+	Kind  string
+	Line  int    // 1-based line number
+	Text  string // the line, with the credential masked
+	start int
+	end   int
+}
+
+// Scan returns the possible credentials in the content, in order of
+// appearance.
+func Scan(content string) []Finding {
+	var spans [][3]int // start, end, index into kinds
+	var kinds []string
+
+	for _, p := range Patterns {
+		for _, loc := range p.Rx.FindAllStringSubmatchIndex(content, -1) {
+			if len(loc) >= 4 {
+				loc = loc[2:4]
+			}
+			if overlaps(spans, loc[0], loc[1]) {
+				continue
+			}
+			spans = append(spans, [3]int{loc[0], loc[1], len(kinds)})
+			kinds = append(kinds, p.Kind)
+		}
+	}
+
+	for _, loc := range rxToken.FindAllStringIndex(content, -1) {
+		if overlaps(spans, loc[0], loc[1]) {
+			continue
+		}
+		if highEntropy(content[loc[0]:loc[1]]) {
+			spans = append(spans, [3]int{loc[0], loc[1], len(kinds)})
+			kinds = append(kinds, "High-entropy string")
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	findings := []Finding{}
+	for _, s := range spans {
+		lineStart := strings.LastIndex(content[:s[0]], "\n") + 1
+		lineEnd := strings.Index(content[s[0]:], "\n")
+		if lineEnd < 0 {
+			lineEnd = len(content)
+		} else {
+			lineEnd += s[0]
+		}
+
+		end := s[1]
+		if end > lineEnd {
+			end = lineEnd
+		}
+
+		findings = append(findings, Finding{
+			Kind:  kinds[s[2]],
+			Line:  strings.Count(content[:s[0]], "\n") + 1,
+			Text:  content[lineStart:s[0]] + mask(content[s[0]:end]) + content[end:lineEnd],
+			start: s[0],
+			end:   s[1],
+		})
+	}
+
+	return findings
+}
+
+// Redact replaces the credentials in the findings, which must have come from
+// scanning the same content, with a placeholder.
+func Redact(content string, findings []Finding) string {
+	var b strings.Builder
+	pos := 0
+	for _, f := range findings {
+		if f.start < pos {
+			continue // overlaps the previous finding
+		}
+		b.WriteString(content[pos:f.start])
+		b.WriteString("[REDACTED]")
+		pos = f.end
+	}
+	b.WriteString(content[pos:])
+
+	return b.String()
+}
+
+// mask hides all but the first four characters of a credential.
+func mask(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", len(s)-4)
+}
+
+// highEntropy reports whether the token looks random enough to be a
+// credential. It has to mix letters and digits, which rules out most
+// identifiers, and have a high Shannon entropy for its alphabet.
+func highEntropy(token string) bool {
+	if !strings.ContainsAny(token, "0123456789") || strings.IndexFunc(token, isLetter) < 0 {
+		return false
+	}
+
+	if rxHex.MatchString(token) {
+		// Hex strings need to be longer than a SHA-1 hash, as those are
+		// commonly pasted as commit IDs.
+		return len(token) >= 48 && entropy(token) >= 3.0
+	}
+	return entropy(token) >= 4.2
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// entropy returns the Shannon entropy of the string, in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+
+	var h float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+func overlaps(spans [][3]int, start, end int) bool {
+	for _, s := range spans {
+		if start < s[1] && s[0] < end {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+// Package totp implements the time-based one-time password algorithm from RFC
+// 6238, using the defaults understood by common authenticator apps (HMAC-SHA1,
+// six digits and a 30 second period).
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the length of the generated codes.
+	Digits = 6
+
+	// Period is the number of seconds each code is valid for.
+	Period = 30
+
+	// Skew is the number of periods either side of the current one which are
+	// also accepted, to allow for clock drift.
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// modulus is 10^Digits, which codes are reduced modulo to give Digits digits.
+var modulus = func() uint32 {
+	m := uint32(1)
+	for i := 0; i < Digits; i++ {
+		m *= 10
+	}
+	return m
+}()
+
+// GenerateSecret returns a new random 160-bit secret, base32 encoded as
+// expected by authenticator apps.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the time step that t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code for the given secret and time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, as described in RFC 4226 section 5.3.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%modulus), nil
+}
+
+// Validate checks the code against the secret at time t. To stop a code being
+// replayed, codes from time steps up to and including lastStep are rejected.
+// If the code is valid the step it belongs to is returned, which should be
+// stored and passed as lastStep next time.
+func Validate(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// URL returns the otpauth:// URL used to enroll the secret in an
+// authenticator app, usually by rendering it as a QR code.
+func URL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
@@ -0,0 +1,107 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed from RFC 6238 appendix B, "12345678901234567890",
+// base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The RFC gives eight digit codes; ours are their last Digits digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		want := tt.code[len(tt.code)-Digits:]
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if got != want {
+			t.Errorf("Code at %d = %q, want %q", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	got, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "287082"; got != want {
+		t.Errorf("Code = %q, want %q", got, want)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Error("Code with an invalid secret succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current", code(current), 0, current, true},
+		{"previous", code(current - Skew), 0, current - Skew, true},
+		{"next", code(current + Skew), 0, current + Skew, true},
+		{"too old", code(current - Skew - 1), 0, 0, false},
+		{"too new", code(current + Skew + 1), 0, 0, false},
+		{"replayed", code(current), current, 0, false},
+		{"after an earlier one", code(current), current - 1, current, true},
+		{"wrong", "000000", 0, 0, false},
+		{"empty", "", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("Validate = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q isn't base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret is %d bytes, want 20", len(key))
+	}
+}
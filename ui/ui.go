@@ -0,0 +1,10 @@
+// Package ui embeds the templates and static assets, so that the server can
+// be deployed as a single binary.
+package ui
+
+import "embed"
+
+// Files holds the html and static directories.
+//
+//go:embed html static
+var Files embed.FS